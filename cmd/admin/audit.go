@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	wb "github.com/webhook-processor/internal/webhook/domain/service"
+
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+)
+
+func runAudit(ctx context.Context, command string, args []string) error {
+	if command != "list" {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	filter := wb_model.AuditFilter{Limit: wb_model.DEFAULT_AUDIT_PAGE_SIZE}
+	if len(args) > 0 {
+		limit, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid limit %q", args[0])
+		}
+		filter.Limit = limit
+	}
+	if len(args) > 1 {
+		filter.Action = wb_model.AuditAction(args[1])
+	}
+
+	audit := wb.NewAuditService(wb_repo.NewAuditRepo(gorm.NewDB(gorm.DbOptionsFromEnv())))
+	page, err := audit.ListAuditEntries(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tAT\tACTOR\tACTION\tTARGET\tTENANT\tREASON\tCHANGES")
+	for _, entry := range page.Entries {
+		changes, err := json.Marshal(entry.Changes.Data())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s/%s\t%s\t%s\t%s\n", entry.Id, entry.CreatedAt.UTC().Format(time.RFC3339), entry.Actor,
+			entry.Action, entry.TargetType, entry.TargetId, entry.TenantId, entry.Reason, changes)
+	}
+	return w.Flush()
+}
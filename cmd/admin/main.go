@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/webhook-processor/internal/webhook/adapters/queue"
+	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	wb "github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+)
+
+const usage = `usage: admin parking <command>
+       admin tenants <command>
+       admin audit <command>
+
+parking commands:
+  list [limit]       list parked messages
+  inspect <id>       print a parked message with its headers and body
+  requeue <id|all>   move parked messages back to the work queue
+
+tenants commands:
+  list                    list tenants with their settings
+  create <id> <name>      create an active tenant
+  token <id>              issue a new API token, the previous one stops working
+  settings <id> <json>    replace the settings, e.g. {"max_attempts":3,
+                          "rate_limit_per_second":10,"signing_mode":"none"}
+  enable <id>             let the tenant deliver and use the API again
+  disable <id>            stop deliveries and API access of the tenant
+
+audit commands:
+  list [limit] [action]   list the audit log of every tenant, newest first
+
+env:
+  AUDIT_ACTOR    who runs the command (default operator:$USER)
+  AUDIT_REASON   why, recorded with every change
+`
+
+func main() {
+	logger := log.NewLogger(log.LoggerOptsFromEnv("ADMIN", "warn"))
+	logger.SetAsDefaultForPackage()
+
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// admin commands operate across tenants
+	ctx := wb_model.AllTenants(context.Background())
+	var err error
+	switch os.Args[1] {
+	case "parking":
+		err = runParking(ctx, os.Args[2], os.Args[3:])
+	case "tenants":
+		err = runTenants(ctx, os.Args[2], os.Args[3:])
+	case "audit":
+		err = runAudit(ctx, os.Args[2], os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func runParking(ctx context.Context, command string, args []string) error {
+	// the database holds the audit log, and the queue on the postgres backend
+	db := gorm.NewDB(gorm.DbOptionsFromEnv())
+	opts := queue.BackendOptsFromEnv(nil)
+	if opts.Backend == "postgres" {
+		opts.DB = db
+	}
+
+	connector, err := queue.NewConnector(opts)
+	if err != nil {
+		return err
+	}
+	defer connector.Close()
+
+	admin, ok := connector.(queue.ParkingAdmin)
+	if !ok {
+		return fmt.Errorf("queue backend %q does not support parking administration", opts.Backend)
+	}
+
+	switch command {
+	case "list":
+		limit := 50
+		if len(args) > 0 {
+			if limit, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("invalid limit %q", args[0])
+			}
+		}
+		return listParked(ctx, admin, limit)
+	case "inspect":
+		if len(args) == 0 {
+			return errors.New("inspect needs a parked message id")
+		}
+		return inspectParked(ctx, admin, args[0])
+	case "requeue":
+		if len(args) == 0 {
+			return errors.New("requeue needs a parked message id or all")
+		}
+		audit := wb.NewAuditService(wb_repo.NewAuditRepo(db))
+		return requeueParked(wb.OperatorContextFromEnv(ctx), admin, audit, args[0])
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %q", command)
+}
+
+func listParked(ctx context.Context, admin queue.ParkingAdmin, limit int) error {
+	parked, err := admin.ListParked(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMESSAGE ID\tREASON\tPARKED AT\tERROR")
+	for _, msg := range parked {
+		h := msg.Metadata.Headers
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", msg.Id, msg.Metadata.MessageId, msg.Reason(), h[queue.HEADER_PARKED_AT], h[queue.HEADER_PARKED_ERROR])
+	}
+	return w.Flush()
+}
+
+func inspectParked(ctx context.Context, admin queue.ParkingAdmin, id string) error {
+	parked, err := admin.ListParked(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range parked {
+		if msg.Id != id {
+			continue
+		}
+
+		fmt.Printf("id:         %s\n", msg.Id)
+		fmt.Printf("message id: %s\n", msg.Metadata.MessageId)
+		fmt.Printf("type:       %s\n", msg.Metadata.Type)
+		fmt.Printf("timestamp:  %s\n", msg.Metadata.Timestamp)
+		fmt.Println("headers:")
+		for key, value := range msg.Metadata.Headers {
+			if key == queue.HEADER_PARKED_STACK {
+				continue
+			}
+			fmt.Printf("  %s: %s\n", key, value)
+		}
+		fmt.Printf("body:\n%s\n", msg.Body)
+		if stack := msg.Metadata.Headers[queue.HEADER_PARKED_STACK]; stack != "" {
+			fmt.Printf("stack:\n%s\n", stack)
+		}
+		return nil
+	}
+
+	return queue.ErrParkedMessageNotFound
+}
+
+// requeueParked requeues one parked message, or every one with id all, and
+// audits each of them.
+func requeueParked(ctx context.Context, admin queue.ParkingAdmin, audit ports.AuditPort, id string) error {
+	parked, err := admin.ListParked(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, msg := range parked {
+		if id != "all" && msg.Id != id {
+			continue
+		}
+		found = true
+
+		if err := admin.RequeueParked(ctx, msg.Id); err != nil {
+			return fmt.Errorf("requeue %s: %w", msg.Id, err)
+		}
+		target := wb_model.AuditTarget{
+			Type:     wb_model.AuditTargetParkedMessage,
+			Id:       msg.Id,
+			TenantId: msg.Metadata.Headers[ports.HEADER_TENANT_ID],
+		}
+		before := map[string]string{"queue": wb_model.WEBHOOK_PARKING_QUEUE, "message_id": msg.Metadata.MessageId, "reason": msg.Reason()}
+		after := map[string]string{"queue": wb_model.WEBHOOK_QUEUE, "message_id": msg.Metadata.MessageId}
+		if err := audit.Record(ctx, wb_model.AuditActionParkedRequeue, target, before, after); err != nil {
+			return fmt.Errorf("requeued %s, audit failed: %w", msg.Id, err)
+		}
+		fmt.Println("requeued", msg.Id)
+	}
+
+	if !found && id != "all" {
+		return queue.ErrParkedMessageNotFound
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	wb "github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
+
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+)
+
+func runTenants(ctx context.Context, command string, args []string) error {
+	db := gorm.NewDB(gorm.DbOptionsFromEnv())
+	tenants := wb_repo.NewTenantRepo(db)
+	audit := wb.NewAuditService(wb_repo.NewAuditRepo(db))
+	ctx = wb.OperatorContextFromEnv(ctx)
+
+	switch command {
+	case "list":
+		return listTenants(ctx, tenants)
+	case "create":
+		if len(args) < 2 {
+			return errors.New("create needs a tenant id and name")
+		}
+		// the tenant is only created with its audit entry
+		var tenant *wb_model.Tenant
+		err := tenants.WithinTransaction(ctx, func(ctx context.Context) (err error) {
+			tenant, err = tenants.CreateTenant(ctx, wb_model.Tenant{Id: args[0], Name: args[1]})
+			if err != nil {
+				return err
+			}
+			return audit.Record(ctx, wb_model.AuditActionTenantCreate, tenantTarget(tenant.Id), nil, tenant)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", tenant.Id)
+		return nil
+	case "token":
+		if len(args) == 0 {
+			return errors.New("token needs a tenant id")
+		}
+		var token string
+		err := updateTenant(ctx, tenants, audit, wb_model.AuditActionTenantTokenRotate, args[0], func(ctx context.Context) (err error) {
+			token, err = tenants.IssueAPIToken(ctx, args[0])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	case "settings":
+		if len(args) < 2 {
+			return errors.New("settings needs a tenant id and a JSON object")
+		}
+		settings := wb_model.TenantSettings{}
+		if err := json.Unmarshal([]byte(args[1]), &settings); err != nil {
+			return fmt.Errorf("invalid settings: %w", err)
+		}
+		err := updateTenant(ctx, tenants, audit, wb_model.AuditActionTenantSettings, args[0], func(ctx context.Context) error {
+			return tenants.UpdateTenantSettings(ctx, args[0], settings)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("updated", args[0])
+		return nil
+	case "enable", "disable":
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs a tenant id", command)
+		}
+		status := wb_model.TenantStatusActive
+		if command == "disable" {
+			status = wb_model.TenantStatusDisabled
+		}
+		err := updateTenant(ctx, tenants, audit, wb_model.AuditActionTenantStatus, args[0], func(ctx context.Context) error {
+			return tenants.UpdateTenantStatus(ctx, args[0], status)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(command+"d", args[0])
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// updateTenant runs update and audits the tenant as it was before and
+// after it, both are committed together so a change is never left
+// unaudited.
+func updateTenant(ctx context.Context, tenants *wb_repo.TenantRepo, audit ports.AuditPort, action wb_model.AuditAction, id string, update func(ctx context.Context) error) error {
+	return tenants.WithinTransaction(ctx, func(ctx context.Context) error {
+		before, err := tenants.GetTenant(ctx, id)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return wb_repo.ErrTenantNotFound
+		}
+		if err := update(ctx); err != nil {
+			return err
+		}
+
+		after, err := tenants.GetTenant(ctx, id)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, action, tenantTarget(id), before, after)
+	})
+}
+
+func tenantTarget(id string) wb_model.AuditTarget {
+	return wb_model.AuditTarget{Type: wb_model.AuditTargetTenant, Id: id, TenantId: id}
+}
+
+func listTenants(ctx context.Context, tenants *wb_repo.TenantRepo) error {
+	list, err := tenants.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tAPI TOKEN\tSETTINGS")
+	for _, tenant := range list {
+		settings, err := json.Marshal(tenant.Settings.Data())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", tenant.Id, tenant.Name, tenant.Status, tenant.APITokenHash != nil, settings)
+	}
+	return w.Flush()
+}
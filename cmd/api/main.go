@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/adapters/api"
+	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
+	wb "github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
+
+	"github.com/webhook-processor/internal/shared/crypto"
+	env "github.com/webhook-processor/internal/shared/env"
+	"github.com/webhook-processor/internal/shared/health"
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+	"github.com/webhook-processor/internal/shared/tracing"
+)
+
+func main() {
+	logger := log.NewLogger(log.LoggerOptsFromEnv("API", "info"))
+	logger.SetAsDefaultForPackage()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.TracingOptsFromEnv("webhook-processor-api"))
+	if err != nil {
+		log.Error("Error setting up tracing", "err", err)
+		os.Exit(1)
+	}
+
+	db := gorm.NewDB(gorm.DbOptionsFromEnv())
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Error("Error getting database handle", "err", err)
+		os.Exit(1)
+	}
+
+	var repo ports.WebhookRepositoryPort = wb_repo.NewWebhookRepo(db)
+	keyProvider, err := crypto.KeyProviderFromEnv()
+	if err != nil {
+		log.Error("Error loading encryption keys", "err", err)
+		os.Exit(1)
+	}
+	if keyProvider != nil {
+		repo = wb_repo.NewEncryptedWebhookRepo(repo, wb_repo.NewEncryptor(wb_repo.NewDataKeyRepo(db), keyProvider))
+	}
+	repo = wb_repo.NewTracedWebhookRepo(repo)
+
+	mux := http.NewServeMux()
+	api.NewWebhookEventsHandler(wb.NewWebhookQueryService(repo)).Register(mux)
+	api.NewAuditHandler(wb.NewAuditService(wb_repo.NewAuditRepo(db))).Register(mux)
+	api.NewDeliveryStatsHandler(wb.NewDeliveryStatsQueryService(repo, wb_repo.NewDeliveryStatsRepo(db))).Register(mux)
+
+	// probes are served without authentication
+	probes := health.New()
+	probes.Readiness("database", health.Ping(sqlDB))
+	root := http.NewServeMux()
+	probes.Register(root)
+	root.Handle("/", api.RequireTenant(wb_repo.NewTenantRepo(db), os.Getenv("API_ADMIN_TOKEN"), mux))
+
+	server := &http.Server{
+		Addr:              env.GetEnvOrDefault("API_ADDR", ":8080"),
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Info("API listening", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("Error serving API", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Info("Shutdown signal received, stopping API...")
+
+	// fail readiness while still serving so the load balancer stops
+	// routing here before the listener closes
+	probes.SetDraining()
+	drainDelay := 3 * time.Second
+	log.Info("Draining", "delay", drainDelay)
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Error shutting down API", "err", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error("Error flushing traces", "err", err)
+	}
+}
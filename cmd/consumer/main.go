@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	nethttp "net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -11,52 +15,98 @@ import (
 	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
 	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
 	wb "github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
 
+	"github.com/webhook-processor/internal/shared/crypto"
 	env "github.com/webhook-processor/internal/shared/env"
+	"github.com/webhook-processor/internal/shared/health"
 	"github.com/webhook-processor/internal/shared/http"
 	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/metrics"
 	"github.com/webhook-processor/internal/shared/persistence/gorm"
+	"github.com/webhook-processor/internal/shared/persistence/migrations"
+	"github.com/webhook-processor/internal/shared/tracing"
+	gormio "gorm.io/gorm"
 )
 
 func main() {
-	logger := log.NewLogger(
-		&log.NewLoggerOptions{
-			Prefix: "CONSUMER",
-			Level:  env.GetEnvOrDefault("LOG_LEVEL", "debug"),
-		},
-	)
+	logger := log.NewLogger(log.LoggerOptsFromEnv("CONSUMER", "debug"))
 	logger.SetAsDefaultForPackage()
 
-	db := gorm.NewDB(gorm.DbOptions{
-		Host:     env.GetEnvOrDefault("POSTGRES_HOST", "localhost"),
-		DbName:   env.GetEnvOrDefault("POSTGRES_DB", "webhook_processor"),
-		User:     env.GetEnvOrDefault("POSTGRES_USER", "webhook_user"),
-		Password: env.GetEnvOrDefault("POSTGRES_PASSWORD", "webhook_pass"),
-		Schema:   env.GetEnvOrDefault("POSTGRES_SCHEMA", "webhooks"),
-	})
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.TracingOptsFromEnv("webhook-processor-consumer"))
+	if err != nil {
+		log.Error("Error setting up tracing", "err", err)
+		os.Exit(1)
+	}
+
+	dbOpts := gorm.DbOptionsFromEnv()
+	db := gorm.NewDB(dbOpts)
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Error("Error getting database handle", "err", err)
+		os.Exit(1)
+	}
+	if env.GetEnvOrDefault("DB_AUTO_MIGRATE", strconv.FormatBool(dbOpts.Driver == gorm.DriverSqlite)) == "true" {
+		if err := migrate(db, dbOpts); err != nil {
+			log.Error("Error migrating database", "err", err)
+			os.Exit(1)
+		}
+	}
 
 	log.Info("Starting Webhook Processor Consumer...")
 
-	connector := queue.NewRabbitMQConnector(&queue.RabbitMQConnOpts{
-		QueueName:    wb_model.WEBHOOK_QUEUE,
-		ExchangeName: wb_model.EXCHANGE_NAME,
-		RoutingKey:   wb_model.ROUTING_KEY,
-	})
+	connector, err := queue.NewConnector(queue.BackendOptsFromEnv(db))
+	if err != nil {
+		log.Error("Error creating queue connector", "err", err)
+		os.Exit(1)
+	}
 
-	repo := wb_repo.NewWebhookRepo(db)
-	http_client := http.NewClient(http.ClientOpts{Timeout: time.Second * 5})
-	wb_service := wb.NewWebhookService(repo, http_client)
+	var repo ports.WebhookRepositoryPort = wb_repo.NewWebhookRepo(db)
+	keyProvider, err := crypto.KeyProviderFromEnv()
+	if err != nil {
+		log.Error("Error loading encryption keys", "err", err)
+		os.Exit(1)
+	}
+	if keyProvider != nil {
+		repo = wb_repo.NewEncryptedWebhookRepo(repo, wb_repo.NewEncryptor(wb_repo.NewDataKeyRepo(db), keyProvider))
+	}
+	repo = wb_repo.NewTracedWebhookRepo(repo)
+	statsRepo := wb_repo.NewDeliveryStatsRepo(db)
+	http_client := http.NewClient(http.ClientOpts{Timeout: wb_model.MAX_WEBHOOK_TIMEOUT})
+	wb_service := wb.NewWebhookService(repo, statsRepo, http_client)
 	rabbitMQConsumer := wb_queue.NewRabbitMQConsumer(wb_service, connector)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sweeperInterval := durationFromEnv("SWEEPER_INTERVAL", "1m")
+	sweeperThreshold := durationFromEnv("SWEEPER_THRESHOLD", "5m")
+	sweeper := wb.NewStuckEventSweeper(repo, connector, wb.NewAuditService(wb_repo.NewAuditRepo(db)), wb.StuckEventSweeperOpts{
+		Interval:  sweeperInterval,
+		Threshold: sweeperThreshold,
+		Producer:  "consumer/sweeper",
+	})
+	sweeperDone := runUntilDone(ctx, sweeper.Run)
+
+	rollupInterval := durationFromEnv("STATS_ROLLUP_INTERVAL", "1m")
+	rollupLookback := durationFromEnv("STATS_ROLLUP_LOOKBACK", "5m")
+	rollup := wb.NewDeliveryStatsRollup(repo, statsRepo, wb.DeliveryStatsRollupOpts{
+		Interval: rollupInterval,
+		Lookback: rollupLookback,
+	})
+	rollupDone := runUntilDone(ctx, rollup.Run)
+
+	workers, _ := strconv.Atoi(env.GetEnvOrDefault("CONSUMER_WORKERS", "1"))
 	msgs := connector.Listen()
-	go func() {
-		for d := range msgs {
-			err := rabbitMQConsumer.Consume(d)
-			if err != nil {
-				log.Error("Error consuming message", err)
-			}
-		}
-	}()
+	consumerDone := runUntilDone(ctx, func(ctx context.Context) { rabbitMQConsumer.Run(ctx, msgs, workers) })
+
+	probes := health.New()
+	probes.Liveness("broker", connector.Ping)
+	probes.Liveness("consumer", rabbitMQConsumer.Subscribed)
+	probes.Readiness("database", health.Ping(sqlDB))
+	probes.Readiness("deliveries", rabbitMQConsumer.Healthy)
+	probes.Detail("last_delivery", health.Since(rabbitMQConsumer.LastDelivery))
+	opsServer := serveOps(env.GetEnvOrDefault("METRICS_ADDR", ":9090"), probes)
 
 	log.Info("waiting for messages...")
 
@@ -65,14 +115,109 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	log.Info("Shutdown signal received, stopping consumer...")
+	probes.SetDraining()
 
 	shutdownTimeout := 3 * time.Second
 	log.Info("Waiting for graceful shutdown", "timeout", shutdownTimeout)
 	time.Sleep(shutdownTimeout)
+	// abort deliveries still in flight, their messages are requeued
+	cancel()
+	// the workers ack or nack on the connection, it is closed once they stopped
+	stopTimeout := 10 * time.Second
+	if !waitDone(stopTimeout, consumerDone, sweeperDone, rollupDone) {
+		log.Error("Workers still running, closing the broker connection", "timeout", stopTimeout)
+	}
 
 	if err := connector.Close(); err != nil {
-		log.Error("Error closing broker connection", err)
+		log.Error("Error closing broker connection", "err", err)
+	}
+	if opsServer != nil {
+		opsServer.Close()
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error("Error flushing traces", "err", err)
 	}
 
 	log.Info("Consumer stopped successfully")
 }
+
+// runUntilDone runs fn in a goroutine, the returned channel is closed when
+// fn returns.
+func runUntilDone(ctx context.Context, fn func(ctx context.Context)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	return done
+}
+
+// waitDone waits for every channel to be closed, it reports false when
+// timeout passes first.
+func waitDone(timeout time.Duration, dones ...<-chan struct{}) bool {
+	deadline := time.After(timeout)
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// durationFromEnv stops the consumer when key is not a valid duration, a
+// typo must not fall back to the default silently.
+func durationFromEnv(key string, defaultValue string) time.Duration {
+	value := env.GetEnvOrDefault(key, defaultValue)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Error("Invalid duration", "key", key, "value", value, "err", err)
+		os.Exit(1)
+	}
+	return d
+}
+
+// serveOps exposes GET /metrics, /healthz and /readyz on addr, an empty
+// addr disables them.
+func serveOps(addr string, probes *health.Health) *nethttp.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := nethttp.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	probes.Register(mux)
+	server := &nethttp.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		log.Info("Metrics and health listening", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+			log.Error("Error serving metrics and health", "err", err)
+		}
+	}()
+	return server
+}
+
+// migrate applies pending migrations on start, it is the default on sqlite
+// so the processor runs as a single binary.
+func migrate(db *gormio.DB, opts gorm.DbOptions) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migrations.NewMigrator(sqlDB, migrations.MigratorOpts{
+		Dialect: opts.Driver,
+		Schema:  opts.Schema,
+	})
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if applied > 0 {
+		log.Info("Migrations applied", "count", applied)
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	wb_repo "github.com/webhook-processor/internal/webhook/adapters/repo"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	wb "github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
+
+	"github.com/webhook-processor/internal/shared/crypto"
+	env "github.com/webhook-processor/internal/shared/env"
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+	gormio "gorm.io/gorm"
+)
+
+const usage = `usage: maintenance <command>
+
+commands:
+  partitions         create upcoming partitions, archive expired rows to
+                     PARTITION_ARCHIVE_DIR and drop expired partitions,
+                     meant to run daily from cron
+  encrypt-existing   encrypt secrets and payloads stored in plaintext
+  rekey              rewrap every data key with the current master key
+  kms-rotate         create a new master key in the local KMS (run rekey after)
+  stats-rollup <from> [to]
+                     recompute the delivery stats of the attempts made
+                     between from and to (RFC 3339, to defaults to now)
+
+env:
+  PARTITION_PREMAKE_MONTHS      months created ahead (default 3)
+  PARTITION_RETENTION           retention of rows (default 2160h)
+  PARTITION_RETENTION_BY_STATUS e.g. failed=4320h,dead_letter=4320h
+  PARTITION_ARCHIVE_DIR         archive directory (default ./archive)
+  ENCRYPTION_KEY_PROVIDER       env, file or kms
+  AUDIT_ACTOR                   who runs the command (default operator:$USER)
+  AUDIT_REASON                  why, recorded in the audit log
+`
+
+func main() {
+	logger := log.NewLogger(log.LoggerOptsFromEnv("MAINTENANCE", "info"))
+	logger.SetAsDefaultForPackage()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	// maintenance jobs operate across tenants
+	ctx := wb_model.AllTenants(wb.OperatorContextFromEnv(context.Background()))
+	switch os.Args[1] {
+	case "partitions":
+		err = runPartitions(ctx)
+	case "encrypt-existing":
+		err = runEncryptExisting(ctx)
+	case "rekey":
+		err = runRekey(ctx)
+	case "kms-rotate":
+		err = runKMSRotate(ctx)
+	case "stats-rollup":
+		err = runStatsRollup(ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func newDB() *gormio.DB {
+	opts := gorm.DbOptionsFromEnv()
+	// a single connection keeps the search_path set by NewDB
+	opts.MaxOpenConns = 1
+	return gorm.NewDB(opts)
+}
+
+func runPartitions(ctx context.Context) error {
+	opts, err := partitionOptsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	db := newDB()
+	// each drop commits with its audit entry, a partition whose entry can't
+	// be written is kept
+	audit := newAudit(db)
+	opts.OnDrop = func(ctx context.Context, partition string, archived int64) error {
+		target := wb_model.AuditTarget{Type: wb_model.AuditTargetPartition, Id: partition}
+		before := map[string]interface{}{"archived_rows": archived, "archive_dir": opts.ArchiveDir}
+		return audit.Record(ctx, wb_model.AuditActionPartitionDrop, target, before, nil)
+	}
+
+	report, err := wb_repo.NewPartitionManager(db, opts).Run(ctx)
+	fmt.Printf("created: %v\n", report.Created)
+	for partition, rows := range report.Archived {
+		fmt.Printf("archived: %s %d rows\n", partition, rows)
+	}
+	fmt.Printf("dropped: %v\n", report.Dropped)
+	return err
+}
+
+func runEncryptExisting(ctx context.Context) error {
+	db := newDB()
+	encryptor, err := newEncryptor(db)
+	if err != nil {
+		return err
+	}
+
+	repo := wb_repo.NewEncryptedWebhookRepo(wb_repo.NewWebhookRepo(db), encryptor)
+	webhooks, events, err := wb_repo.EncryptExisting(ctx, db, repo, 500)
+	fmt.Printf("encrypted: %d webhooks, %d events\n", webhooks, events)
+	if webhooks == 0 && events == 0 {
+		return err
+	}
+
+	target := wb_model.AuditTarget{Type: wb_model.AuditTargetDataKeys, Id: "*"}
+	after := map[string]int{"encrypted_webhooks": webhooks, "encrypted_events": events}
+	if auditErr := newAudit(db).Record(ctx, wb_model.AuditActionSecretsEncrypt, target, nil, after); auditErr != nil {
+		return errors.Join(err, fmt.Errorf("audit failed: %w", auditErr))
+	}
+	return err
+}
+
+func runRekey(ctx context.Context) error {
+	db := newDB()
+	encryptor, err := newEncryptor(db)
+	if err != nil {
+		return err
+	}
+
+	rewrapped, err := encryptor.Rekey(ctx, 500)
+	fmt.Printf("rewrapped: %d data keys\n", rewrapped)
+	if rewrapped == 0 {
+		return err
+	}
+
+	target := wb_model.AuditTarget{Type: wb_model.AuditTargetDataKeys, Id: "*"}
+	if auditErr := newAudit(db).Record(ctx, wb_model.AuditActionDataKeysRekey, target, nil, map[string]int{"rewrapped": rewrapped}); auditErr != nil {
+		return errors.Join(err, fmt.Errorf("audit failed: %w", auditErr))
+	}
+	return err
+}
+
+func runKMSRotate(ctx context.Context) error {
+	kms, err := crypto.NewLocalKMS(env.GetEnvOrDefault("ENCRYPTION_KMS_DIR", "./kms"))
+	if err != nil {
+		return err
+	}
+
+	previous := kms.CurrentKeyId()
+	id, err := kms.Rotate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current master key: %s\n", id)
+
+	target := wb_model.AuditTarget{Type: wb_model.AuditTargetMasterKey, Id: id}
+	before := map[string]string{"current": previous}
+	after := map[string]string{"current": id}
+	if err := newAudit(newDB()).Record(ctx, wb_model.AuditActionMasterKeyRotate, target, before, after); err != nil {
+		return fmt.Errorf("rotated, audit failed: %w", err)
+	}
+	return nil
+}
+
+func runStatsRollup(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("stats-rollup needs a from time")
+	}
+	from, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return fmt.Errorf("invalid from %q: %w", args[0], err)
+	}
+	to := time.Now()
+	if len(args) > 1 {
+		if to, err = time.Parse(time.RFC3339, args[1]); err != nil {
+			return fmt.Errorf("invalid to %q: %w", args[1], err)
+		}
+	}
+
+	db := newDB()
+	rollup := wb.NewDeliveryStatsRollup(wb_repo.NewWebhookRepo(db), wb_repo.NewDeliveryStatsRepo(db), wb.DeliveryStatsRollupOpts{})
+	buckets, err := rollup.Rollup(ctx, from, to)
+	fmt.Printf("rolled up: %d buckets\n", buckets)
+	return err
+}
+
+func newAudit(db *gormio.DB) ports.AuditPort {
+	return wb.NewAuditService(wb_repo.NewAuditRepo(db))
+}
+
+func newEncryptor(db *gormio.DB) (*wb_repo.Encryptor, error) {
+	provider, err := crypto.KeyProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return nil, errors.New("encryption is disabled, set ENCRYPTION_KEY_PROVIDER")
+	}
+
+	return wb_repo.NewEncryptor(wb_repo.NewDataKeyRepo(db), provider), nil
+}
+
+func partitionOptsFromEnv() (wb_repo.PartitionManagerOpts, error) {
+	opts := wb_repo.PartitionManagerOpts{
+		ArchiveDir:        env.GetEnvOrDefault("PARTITION_ARCHIVE_DIR", "./archive"),
+		RetentionByStatus: map[string]time.Duration{},
+	}
+
+	var err error
+	if opts.PremakeMonths, err = strconv.Atoi(env.GetEnvOrDefault("PARTITION_PREMAKE_MONTHS", "3")); err != nil {
+		return opts, fmt.Errorf("PARTITION_PREMAKE_MONTHS: %w", err)
+	}
+	if opts.Retention, err = time.ParseDuration(env.GetEnvOrDefault("PARTITION_RETENTION", "2160h")); err != nil {
+		return opts, fmt.Errorf("PARTITION_RETENTION: %w", err)
+	}
+
+	byStatus := env.GetEnvOrDefault("PARTITION_RETENTION_BY_STATUS", "")
+	for _, pair := range strings.Split(byStatus, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		status, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return opts, fmt.Errorf("PARTITION_RETENTION_BY_STATUS: invalid entry %q", pair)
+		}
+		retention, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return opts, fmt.Errorf("PARTITION_RETENTION_BY_STATUS: %w", err)
+		}
+		opts.RetentionByStatus[strings.TrimSpace(status)] = retention
+	}
+
+	return opts, nil
+}
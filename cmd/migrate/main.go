@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/persistence/gorm"
+	"github.com/webhook-processor/internal/shared/persistence/migrations"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up             apply every pending migration
+  down [steps]   revert the last applied migrations (default 1)
+  status         list migrations and whether they are applied
+  verify         check the GORM models against the migrated schema
+`
+
+func main() {
+	logger := log.NewLogger(log.LoggerOptsFromEnv("MIGRATE", "info"))
+	logger.SetAsDefaultForPackage()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	opts := gorm.DbOptionsFromEnv()
+	// a single connection keeps the search_path set by NewDB
+	opts.MaxOpenConns = 1
+	db := gorm.NewDB(opts)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migrations.NewMigrator(sqlDB, migrations.MigratorOpts{
+		Dialect: opts.Driver,
+		Schema:  opts.Schema,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("%d migration(s) applied\n", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", args[0])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		fmt.Printf("%d migration(s) reverted\n", reverted)
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	case "verify":
+		if err := migrations.Verify(db, &wb_model.Tenant{}, &wb_model.Webhook{}, &wb_model.WebhookEvent{}, &wb_model.DeliveryAttempt{}, &wb_model.WebhookDeliveryStats{}, &wb_model.AuditEntry{}); err != nil {
+			return err
+		}
+		fmt.Println("schema matches the models")
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	return nil
+}
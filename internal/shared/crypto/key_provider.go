@@ -0,0 +1,219 @@
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	env "github.com/webhook-processor/internal/shared/env"
+)
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// KeyProvider wraps data keys with master keys it never hands out. Every
+// master key has an id stored next to the wrapped key, so keys wrapped by a
+// rotated master key can still be unwrapped.
+type KeyProvider interface {
+	CurrentKeyId() string
+	WrapKey(ctx context.Context, keyId string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+// keyring keeps master keys in process memory, it backs the env and file
+// providers
+type keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func newKeyring(current string, encoded map[string]string) (*keyring, error) {
+	keys := map[string][]byte{}
+	for id, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+		if len(key) != DATA_KEY_SIZE {
+			return nil, fmt.Errorf("master key %s: must be %d bytes", id, DATA_KEY_SIZE)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current master key %q: %w", current, ErrUnknownMasterKey)
+	}
+
+	return &keyring{current: current, keys: keys}, nil
+}
+
+func (k *keyring) CurrentKeyId() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *keyring) WrapKey(ctx context.Context, keyId string, dataKey []byte) ([]byte, error) {
+	master, err := k.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(master, dataKey, []byte(keyId))
+}
+
+func (k *keyring) UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	master, err := k.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return Open(master, wrapped, []byte(keyId))
+}
+
+func (k *keyring) key(keyId string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyId)
+	}
+	return key, nil
+}
+
+// NewEnvKeyProvider reads MASTER_KEYS as id:base64 pairs separated by
+// commas, MASTER_KEY_ID selects the one used for new data keys.
+func NewEnvKeyProvider() (KeyProvider, error) {
+	encoded := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("MASTER_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, errors.New("MASTER_KEYS: entries must be <id>:<base64 key>")
+		}
+		encoded[id] = value
+	}
+
+	return newKeyring(os.Getenv("MASTER_KEY_ID"), encoded)
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewFileKeyProvider reads {"current": "<id>", "keys": {"<id>": "<base64>"}}.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return newKeyring(file.Current, file.Keys)
+}
+
+// LocalKMS stands in for a managed KMS: it owns its master keys in a
+// directory, creates the first one on demand and can rotate to a new one.
+type LocalKMS struct {
+	*keyring
+	dir string
+}
+
+func NewLocalKMS(dir string) (*LocalKMS, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	kms := &LocalKMS{dir: dir}
+	if err := kms.load(); err != nil {
+		return nil, err
+	}
+	if kms.keyring == nil {
+		if _, err := kms.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return kms, nil
+}
+
+// Rotate creates a new master key and makes it current, older keys are kept
+// to unwrap what they wrapped.
+func (k *LocalKMS) Rotate() (string, error) {
+	key, err := GenerateDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("kms-%d", time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(k.dir, id+".key"), []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(k.dir, "current"), []byte(id), 0o600); err != nil {
+		return "", err
+	}
+
+	return id, k.load()
+}
+
+func (k *LocalKMS) load() error {
+	current, err := os.ReadFile(filepath.Join(k.dir, "current"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.key"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	encoded := map[string]string{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		encoded[strings.TrimSuffix(filepath.Base(path), ".key")] = strings.TrimSpace(string(content))
+	}
+
+	ring, err := newKeyring(strings.TrimSpace(string(current)), encoded)
+	if err != nil {
+		return err
+	}
+	k.keyring = ring
+	return nil
+}
+
+// KeyProviderFromEnv builds the provider selected by ENCRYPTION_KEY_PROVIDER
+// (env, file or kms), it returns nil when encryption is disabled.
+func KeyProviderFromEnv() (KeyProvider, error) {
+	switch provider := env.GetEnvOrDefault("ENCRYPTION_KEY_PROVIDER", ""); provider {
+	case "":
+		return nil, nil
+	case "env":
+		return NewEnvKeyProvider()
+	case "file":
+		return NewFileKeyProvider(env.GetEnvOrDefault("ENCRYPTION_KEY_FILE", "master_keys.json"))
+	case "kms":
+		return NewLocalKMS(env.GetEnvOrDefault("ENCRYPTION_KMS_DIR", "./kms"))
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", provider)
+	}
+}
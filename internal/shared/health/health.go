@@ -0,0 +1,183 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CHECK_TIMEOUT bounds every check of one probe
+const CHECK_TIMEOUT = 2 * time.Second
+
+const (
+	STATUS_OK          = "ok"
+	STATUS_UNAVAILABLE = "unavailable"
+	STATUS_DRAINING    = "draining"
+)
+
+type Check func(ctx context.Context) error
+
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Health serves GET /healthz and GET /readyz. Liveness checks fail when only
+// a restart recovers the process, they are part of readiness too. Readiness
+// also fails while draining so no new traffic is routed during shutdown.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	details   []namedDetail
+	draining  atomic.Bool
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type namedDetail struct {
+	name   string
+	detail func() any
+}
+
+type Report struct {
+	Status  string                 `json:"status"`
+	Checks  map[string]CheckResult `json:"checks"`
+	Details map[string]any         `json:"details,omitempty"`
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func New() *Health {
+	return &Health{}
+}
+
+func (h *Health) Liveness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+func (h *Health) Readiness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+// Detail adds an informative value to both reports, it never fails them.
+func (h *Health) Detail(name string, detail func() any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.details = append(h.details, namedDetail{name: name, detail: detail})
+}
+
+// SetDraining fails readiness from now on, it is called on shutdown.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, h.Live(r.Context()))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, h.Ready(r.Context()))
+	})
+}
+
+func (h *Health) Live(ctx context.Context) Report {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.report(ctx, h.liveness)
+}
+
+func (h *Health) Ready(ctx context.Context) Report {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	report := h.report(ctx, append(append([]namedCheck{}, h.liveness...), h.readiness...))
+	if h.Draining() {
+		report.Status = STATUS_DRAINING
+	}
+	return report
+}
+
+// report runs checks concurrently, a slow dependency costs CHECK_TIMEOUT
+// once rather than once per check.
+func (h *Health) report(ctx context.Context, checks []namedCheck) Report {
+	ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+	defer cancel()
+
+	results := make([]CheckResult, len(checks))
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, c.check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: STATUS_OK, Checks: make(map[string]CheckResult, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != STATUS_OK {
+			report.Status = STATUS_UNAVAILABLE
+		}
+	}
+	if len(h.details) > 0 {
+		report.Details = make(map[string]any, len(h.details))
+		for _, d := range h.details {
+			report.Details[d.name] = d.detail()
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, check Check) CheckResult {
+	if err := check(ctx); err != nil {
+		return CheckResult{Status: STATUS_UNAVAILABLE, Error: err.Error()}
+	}
+	return CheckResult{Status: STATUS_OK}
+}
+
+// Ping checks a database handle, *sql.DB implements Pinger.
+func Ping(p Pinger) Check {
+	return p.PingContext
+}
+
+// Since reports when t happened and how many seconds ago, nil while t is
+// zero.
+func Since(t func() time.Time) func() any {
+	return func() any {
+		at := t()
+		if at.IsZero() {
+			return nil
+		}
+		return map[string]any{"at": at.UTC(), "seconds_ago": time.Since(at).Seconds()}
+	}
+}
+
+func write(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status != STATUS_OK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
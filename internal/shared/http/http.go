@@ -1,9 +1,14 @@
 package http
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/webhook-processor/internal/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Response = http.Response
@@ -24,16 +29,16 @@ func NewClient(opts ClientOpts) *HTTPClient {
 	}
 }
 
-func (c *HTTPClient) Get(url string) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func (c *HTTPClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return c.Client.Do(req)
+	return c.do(req)
 }
 
-func (c *HTTPClient) Post(url string, bodyType string, body io.Reader, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, url, body)
+func (c *HTTPClient) Post(ctx context.Context, url string, bodyType string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -41,5 +46,31 @@ func (c *HTTPClient) Post(url string, bodyType string, body io.Reader, headers m
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
-	return c.Client.Do(req)
+	return c.do(req)
+}
+
+// do sends req in a client span and forwards its trace context in the
+// traceparent header so the receiver can join the trace.
+func (c *HTTPClient) do(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.Redacted()),
+			attribute.String("server.address", req.URL.Hostname()),
+		),
+	)
+
+	carrier := map[string]string{}
+	tracing.Inject(ctx, carrier)
+	for key, value := range carrier {
+		req.Header.Set(key, value)
+	}
+
+	res, err := c.Client.Do(req.WithContext(ctx))
+	if res != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	}
+	tracing.End(span, err)
+	return res, err
 }
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handler applies the package levels and writes the trace and span ids of
+// the record context.
+type handler struct {
+	slog.Handler
+	level         slog.Level
+	packageLevels map[string]slog.Level
+	// levels caches the level of a caller pc
+	levels *sync.Map
+}
+
+func newHandler(h slog.Handler, level slog.Level, packageLevels map[string]string) *handler {
+	levels := map[string]slog.Level{}
+	for pkg, name := range packageLevels {
+		if l, ok := LevelMap[name]; ok {
+			levels[pkg] = l
+		}
+	}
+	return &handler{Handler: h, level: level, packageLevels: levels, levels: &sync.Map{}}
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.levelOf(r.PC) {
+		return nil
+	}
+
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{Handler: h.Handler.WithAttrs(attrs), level: h.level, packageLevels: h.packageLevels, levels: h.levels}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{Handler: h.Handler.WithGroup(name), level: h.level, packageLevels: h.packageLevels, levels: h.levels}
+}
+
+// levelOf returns the level of the package that logged, the most specific
+// package key wins.
+func (h *handler) levelOf(pc uintptr) slog.Level {
+	if len(h.packageLevels) == 0 || pc == 0 {
+		return h.level
+	}
+	if l, ok := h.levels.Load(pc); ok {
+		return l.(slog.Level)
+	}
+
+	level, matched := h.level, ""
+	pkg := callerPackage(pc)
+	for key, l := range h.packageLevels {
+		if (pkg == key || strings.HasSuffix(pkg, "/"+key)) && len(key) > len(matched) {
+			level, matched = l, key
+		}
+	}
+	h.levels.Store(pc, level)
+	return level
+}
+
+// callerPackage returns the import path of the function at pc, e.g.
+// github.com/webhook-processor/internal/webhook/adapters/queue.
+func callerPackage(pc uintptr) string {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	name := frame.Function
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
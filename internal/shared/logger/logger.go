@@ -1,8 +1,16 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	env "github.com/webhook-processor/internal/shared/env"
 )
 
 var LevelMap = map[string]slog.Level{
@@ -12,6 +20,18 @@ var LevelMap = map[string]slog.Level{
 	"error": slog.LevelError,
 }
 
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+// REDACTED replaces the value of redacted attributes
+const REDACTED = "[REDACTED]"
+
+// DEFAULT_REDACTED_KEYS are redacted whatever the options, keys match case
+// insensitively and as a suffix (webhook_secret, x-api-token)
+var DEFAULT_REDACTED_KEYS = []string{"secret", "password", "token", "authorization", "api_key"}
+
 type Logger struct {
 	level  slog.Level
 	prefix string
@@ -21,15 +41,55 @@ type Logger struct {
 type NewLoggerOptions struct {
 	Level  string
 	Prefix string
+	// Format is text (default) or json
+	Format string
+	// PackageLevels overrides Level for the packages whose import path ends
+	// with the key, e.g. {"queue": "debug", "repo": "warn"}
+	PackageLevels map[string]string
+	// RedactKeys are redacted on top of DEFAULT_REDACTED_KEYS
+	RedactKeys []string
+	// Redact is called on every attribute after the keys were redacted, it
+	// returns the attribute to write
+	Redact func(attr slog.Attr) slog.Attr
+	// Output defaults to stdout
+	Output io.Writer
+}
+
+// LoggerOptsFromEnv reads LOG_LEVEL (defaults to level), LOG_FORMAT and
+// LOG_PACKAGE_LEVELS ("queue=debug,repo=warn").
+func LoggerOptsFromEnv(prefix string, level string) *NewLoggerOptions {
+	return &NewLoggerOptions{
+		Level:         env.GetEnvOrDefault("LOG_LEVEL", level),
+		Prefix:        prefix,
+		Format:        env.GetEnvOrDefault("LOG_FORMAT", FORMAT_TEXT),
+		PackageLevels: parsePackageLevels(env.GetEnvOrDefault("LOG_PACKAGE_LEVELS", "")),
+	}
 }
 
 func NewLogger(options *NewLoggerOptions) *Logger {
 	l := LevelMap[options.Level]
-	s := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: l}))
-	prefix := options.Prefix
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		// packages may log below the default level, the handler filters
+		Level:       minLevel(l, options.PackageLevels),
+		ReplaceAttr: redactor(options.RedactKeys, options.Redact),
+	}
+	var h slog.Handler
+	if options.Format == FORMAT_JSON {
+		h = slog.NewJSONHandler(output, handlerOpts)
+	} else {
+		h = slog.NewTextHandler(output, handlerOpts)
+	}
 
+	s := slog.New(newHandler(h, l, options.PackageLevels))
+	prefix := options.Prefix
 	if prefix != "" {
-		s.WithGroup(prefix)
+		// an attribute rather than a group, a group would nest every key
+		s = s.With("logger", prefix)
 	}
 
 	return &Logger{
@@ -39,22 +99,110 @@ func NewLogger(options *NewLoggerOptions) *Logger {
 	}
 }
 
+func (l *Logger) SetAsDefaultForPackage() {
+	slog.SetDefault(l.s)
+}
+
+type ctxKey struct{}
+
+// WithContext returns ctx carrying a child of the logger of ctx with args
+// attached, the *Context functions write them on every record.
+func WithContext(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, ctxKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext returns the logger stored by WithContext, the default logger
+// otherwise.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
 func Debug(msg string, args ...any) {
-	slog.Debug(msg, args...)
+	write(context.Background(), slog.Default(), slog.LevelDebug, msg, args)
 }
 
 func Info(msg string, args ...any) {
-	slog.Info(msg, args...)
+	write(context.Background(), slog.Default(), slog.LevelInfo, msg, args)
 }
 
 func Warn(msg string, args ...any) {
-	slog.Warn(msg, args...)
+	write(context.Background(), slog.Default(), slog.LevelWarn, msg, args)
 }
 
 func Error(msg string, args ...any) {
-	slog.Error(msg, args...)
+	write(context.Background(), slog.Default(), slog.LevelError, msg, args)
 }
 
-func (l *Logger) SetAsDefaultForPackage() {
-	slog.SetDefault(l.s)
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	write(ctx, FromContext(ctx), slog.LevelDebug, msg, args)
+}
+
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	write(ctx, FromContext(ctx), slog.LevelInfo, msg, args)
+}
+
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	write(ctx, FromContext(ctx), slog.LevelWarn, msg, args)
+}
+
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	write(ctx, FromContext(ctx), slog.LevelError, msg, args)
+}
+
+// write records the caller of the exported function, not this package, so
+// package levels apply to the code that logs.
+func write(ctx context.Context, l *slog.Logger, level slog.Level, msg string, args []any) {
+	if !l.Enabled(ctx, level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = l.Handler().Handle(ctx, r)
+}
+
+func redactor(keys []string, hook func(slog.Attr) slog.Attr) func([]string, slog.Attr) slog.Attr {
+	redacted := make([]string, 0, len(DEFAULT_REDACTED_KEYS)+len(keys))
+	for _, key := range slices.Concat(DEFAULT_REDACTED_KEYS, keys) {
+		redacted = append(redacted, strings.ToLower(key))
+	}
+
+	return func(groups []string, a slog.Attr) slog.Attr {
+		key := strings.ToLower(a.Key)
+		for _, suffix := range redacted {
+			if strings.HasSuffix(key, suffix) {
+				a.Value = slog.StringValue(REDACTED)
+				break
+			}
+		}
+		if hook != nil {
+			a = hook(a)
+		}
+		return a
+	}
+}
+
+func parsePackageLevels(value string) map[string]string {
+	levels := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		pkg, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && pkg != "" {
+			levels[pkg] = level
+		}
+	}
+	return levels
+}
+
+func minLevel(level slog.Level, packageLevels map[string]string) slog.Level {
+	for _, name := range packageLevels {
+		if l, ok := LevelMap[name]; ok && l < level {
+			level = l
+		}
+	}
+	return level
 }
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "webhook_processor"
+
+// delivery outcomes, skipped deliveries carry the reason they were skipped
+const (
+	OUTCOME_DELIVERED   = "delivered"
+	OUTCOME_RETRY       = "retry"
+	OUTCOME_FAILED      = "failed"
+	OUTCOME_DEAD_LETTER = "dead_letter"
+	OUTCOME_SKIPPED     = "skipped"
+)
+
+// Registry holds every series of the process, it is served by Handler.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	Deliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "deliveries_total",
+		Help:      "Webhook deliveries by outcome, reason is set on skipped deliveries.",
+	}, []string{"outcome", "reason"})
+
+	DeliveryResponses = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "delivery_responses_total",
+		Help:      "Responses of delivery attempts by status code class (2xx..5xx, timeout, network).",
+	}, []string{"class"})
+
+	DeliveryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "delivery_duration_seconds",
+		Help:      "Duration of the HTTP call of delivery attempts per webhook.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"webhook_id"})
+
+	RetryDelay = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "retry_delay_seconds",
+		Help:      "Delays scheduled for retried messages.",
+		Buckets:   []float64{1, 2, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	QueuePublishErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "queue_publish_errors_total",
+		Help:      "Messages that could not be published: publish (broker), retry or park (consumer).",
+	}, []string{"kind"})
+
+	WorkersInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "workers_in_flight",
+		Help:      "Consumer workers processing a message.",
+	})
+
+	BrokerConnected = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "broker_connected",
+		Help:      "1 while the RabbitMQ connection is open, other backends do not report it.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// StatusClass labels a response code, 0 means no response was received.
+func StatusClass(code int, timeout bool) string {
+	switch {
+	case timeout:
+		return "timeout"
+	case code == 0:
+		return "network"
+	default:
+		return strconv.Itoa(code/100) + "xx"
+	}
+}
+
+func ObserveDelivery(webhookId int, started time.Time) {
+	DeliveryDuration.WithLabelValues(strconv.Itoa(webhookId)).Observe(time.Since(started).Seconds())
+}
+
+func SetBrokerConnected(connected bool) {
+	if connected {
+		BrokerConnected.Set(1)
+	} else {
+		BrokerConnected.Set(0)
+	}
+}
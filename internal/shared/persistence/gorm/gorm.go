@@ -4,11 +4,22 @@ import (
 	"fmt"
 	"log"
 
+	env "github.com/webhook-processor/internal/shared/env"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+)
+
 type DbOptions struct {
+	// Driver is postgres (default) or sqlite
+	Driver string
+	// SqlitePath is the database file used by the sqlite driver
+	SqlitePath   string
 	Host         string
 	DbName       string
 	User         string
@@ -19,10 +30,7 @@ type DbOptions struct {
 }
 
 func NewDB(opts DbOptions) *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable",
-		opts.Host, opts.User, opts.Password, opts.DbName)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(dialector(opts), &gorm.Config{})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,6 +51,11 @@ func NewDB(opts DbOptions) *gorm.DB {
 		log.Fatal(err)
 	}
 
+	if opts.Driver == DriverSqlite {
+		fmt.Println("connected on sqlite", opts.SqlitePath)
+		return db
+	}
+
 	fmt.Println("connected on pg")
 
 	if opts.Schema != "" {
@@ -53,3 +66,28 @@ func NewDB(opts DbOptions) *gorm.DB {
 
 	return db
 }
+
+func dialector(opts DbOptions) gorm.Dialector {
+	if opts.Driver == DriverSqlite {
+		// WAL lets readers run next to the writer, writers take the lock
+		// when their transaction begins and wait for it instead of failing
+		dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_txlock=immediate", opts.SqlitePath)
+		return sqlite.Open(dsn)
+	}
+
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable",
+		opts.Host, opts.User, opts.Password, opts.DbName)
+	return postgres.Open(dsn)
+}
+
+func DbOptionsFromEnv() DbOptions {
+	return DbOptions{
+		Driver:     env.GetEnvOrDefault("DB_DRIVER", DriverPostgres),
+		SqlitePath: env.GetEnvOrDefault("SQLITE_PATH", "webhook-processor.db"),
+		Host:       env.GetEnvOrDefault("POSTGRES_HOST", "localhost"),
+		DbName:     env.GetEnvOrDefault("POSTGRES_DB", "webhook_processor"),
+		User:       env.GetEnvOrDefault("POSTGRES_USER", "webhook_user"),
+		Password:   env.GetEnvOrDefault("POSTGRES_PASSWORD", "webhook_pass"),
+		Schema:     env.GetEnvOrDefault("POSTGRES_SCHEMA", "webhooks"),
+	}
+}
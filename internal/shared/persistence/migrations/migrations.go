@@ -0,0 +1,317 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var migrationsFS embed.FS
+
+// Postgres and Sqlite hold the migrations of each database, they evolve
+// separately since sqlite lacks partitions, arrays and JSONB
+var (
+	Postgres, _ = fs.Sub(migrationsFS, "postgres")
+	Sqlite, _   = fs.Sub(migrationsFS, "sqlite")
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectSqlite   = "sqlite"
+)
+
+const MIGRATIONS_TABLE = "schema_migrations"
+
+// MIGRATIONS_LOCK_KEY is the advisory lock held while migrating, so two
+// processes never apply the same migration concurrently
+const MIGRATIONS_LOCK_KEY = "schema_migrations"
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type MigratorOpts struct {
+	// Dialect is postgres (default) or sqlite
+	Dialect string
+	// Schema is created when missing and used as search_path, postgres only
+	Schema string
+	// Source defaults to the embedded migrations of Dialect
+	Source fs.FS
+}
+
+type Migrator struct {
+	db         *sql.DB
+	opts       MigratorOpts
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, opts MigratorOpts) (*Migrator, error) {
+	if opts.Dialect == "" {
+		opts.Dialect = DialectPostgres
+	}
+	if opts.Source == nil {
+		opts.Source = Postgres
+		if opts.Dialect == DialectSqlite {
+			opts.Source = Sqlite
+		}
+	}
+
+	migrations, err := Load(opts.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, opts: opts, migrations: migrations}, nil
+}
+
+// Load reads <version>_<name>.(up|down).sql files, every version needs both.
+func Load(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every pending migration, each one in its own transaction.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			log.Info("applying migration", "version", migration.Version, "name", migration.Name)
+			err := m.run(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					"INSERT INTO "+MIGRATIONS_TABLE+" (version, name) VALUES ("+m.param(1)+", "+m.param(2)+")",
+					migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			log.Info("reverting migration", "version", migration.Version, "name", migration.Name)
+			err := m.run(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					"DELETE FROM "+MIGRATIONS_TABLE+" WHERE version = "+m.param(1), migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			appliedAt, ok := done[migration.Version]
+			status = append(status, MigrationStatus{
+				Migration: migration,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+
+	return status, err
+}
+
+// withLock runs fn on a dedicated connection holding the migrations lock,
+// session settings like search_path stay on that connection. sqlite has no
+// advisory locks, a concurrent run fails on the version primary key.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.opts.Dialect == DialectSqlite {
+		if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+MIGRATIONS_TABLE+` (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`); err != nil {
+			return err
+		}
+		return fn(conn)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", MIGRATIONS_LOCK_KEY); err != nil {
+		return err
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext($1))", MIGRATIONS_LOCK_KEY)
+		if err != nil {
+			log.Error("Error releasing migrations lock", "err", err)
+		}
+	}()
+
+	if m.opts.Schema != "" {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", m.opts.Schema)); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET search_path TO %s", m.opts.Schema)); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+MIGRATIONS_TABLE+` (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) param(n int) string {
+	if m.opts.Dialect == DialectSqlite {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM "+MIGRATIONS_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+
+	return done, rows.Err()
+}
+
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pgorm "github.com/webhook-processor/internal/shared/persistence/gorm"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var models = []interface{}{
+	&wb_model.Tenant{},
+	&wb_model.Webhook{},
+	&wb_model.WebhookEvent{},
+	&wb_model.DeliveryAttempt{},
+	&wb_model.WebhookDeliveryStats{},
+	&wb_model.AuditEntry{},
+}
+
+// TestSqliteUpDown applies every migration, checks the schema against the
+// models, reverts them all and applies them again.
+func TestSqliteUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := pgorm.NewDB(pgorm.DbOptions{Driver: pgorm.DriverSqlite, SqlitePath: filepath.Join(t.TempDir(), "migrations.db")})
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := NewMigrator(sqlDB, MigratorOpts{Dialect: DialectSqlite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := len(migrator.migrations)
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != all {
+		t.Fatalf("up applied %d of %d migrations: %v", applied, all, err)
+	}
+	if err := Verify(db, models...); err != nil {
+		t.Fatalf("schema does not match the models: %v", err)
+	}
+	if applied, err := migrator.Up(ctx); err != nil || applied != 0 {
+		t.Fatalf("second up applied %d migrations: %v", applied, err)
+	}
+
+	if reverted, err := migrator.Down(ctx, all); err != nil || reverted != all {
+		t.Fatalf("down reverted %d of %d migrations: %v", reverted, all, err)
+	}
+	if db.Migrator().HasTable(&wb_model.WebhookEvent{}) {
+		t.Fatal("webhook_events left after reverting every migration")
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != all {
+		t.Fatalf("up after down applied %d of %d migrations: %v", applied, all, err)
+	}
+	if err := Verify(db, models...); err != nil {
+		t.Fatalf("schema does not match the models after down and up: %v", err)
+	}
+}
+
+// TestPostgresUpDown runs the postgres migrations like TestSqliteUpDown, in
+// a schema of the database at TEST_POSTGRES_DSN that is dropped afterwards.
+func TestPostgresUpDown(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+	const schema = "migrations_test"
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	// the search_path set by the migrator stays on its connection, Verify
+	// must read through the same one
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+
+	migrator, err := NewMigrator(sqlDB, MigratorOpts{Dialect: DialectPostgres, Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := len(migrator.migrations)
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != all {
+		t.Fatalf("up applied %d of %d migrations: %v", applied, all, err)
+	}
+	if err := Verify(db, models...); err != nil {
+		t.Fatalf("schema does not match the models: %v", err)
+	}
+
+	if reverted, err := migrator.Down(ctx, all); err != nil || reverted != all {
+		t.Fatalf("down reverted %d of %d migrations: %v", reverted, all, err)
+	}
+	if db.Migrator().HasTable(&wb_model.WebhookEvent{}) {
+		t.Fatal("webhook_events left after reverting every migration")
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != all {
+		t.Fatalf("up after down applied %d of %d migrations: %v", applied, all, err)
+	}
+	if err := Verify(db, models...); err != nil {
+		t.Fatalf("schema does not match the models after down and up: %v", err)
+	}
+}
+
+// TestLoadEmbedded checks both migration sets load, every version has an up
+// and a down file and versions follow each other.
+func TestLoadEmbedded(t *testing.T) {
+	for _, dialect := range []string{DialectPostgres, DialectSqlite} {
+		source := Postgres
+		if dialect == DialectSqlite {
+			source = Sqlite
+		}
+		migrations, err := Load(source)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		for i, m := range migrations {
+			if m.Version != int64(i+1) {
+				t.Fatalf("%s: migration %d_%s found at position %d, versions must follow each other", dialect, m.Version, m.Name, i+1)
+			}
+		}
+	}
+}
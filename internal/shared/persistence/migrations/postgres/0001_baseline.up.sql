@@ -1,8 +1,6 @@
-CREATE SCHEMA IF NOT EXISTS webhooks;
-
-SET search_path TO webhooks;
-
-CREATE TABLE webhooks (
+-- baseline: the schema previously created by deployments/postgres/init.sql,
+-- idempotent so databases bootstrapped from it can adopt migrations
+CREATE TABLE IF NOT EXISTS webhooks (
     id                SERIAL PRIMARY KEY,
     subscribed_events TEXT[] NOT NULL,
     callback_url      TEXT NOT NULL,
@@ -14,7 +12,7 @@ CREATE TABLE webhooks (
     updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
-CREATE TABLE webhook_events (
+CREATE TABLE IF NOT EXISTS webhook_events (
     id               VARCHAR(26) PRIMARY KEY,
     webhook_id       INTEGER NOT NULL REFERENCES webhooks(id),
     event_type       TEXT NOT NULL,
@@ -0,0 +1,3 @@
+-- per webhook delivery deadline, IF NOT EXISTS for databases bootstrapped
+-- from an init.sql that already had it
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS timeout_ms INTEGER NOT NULL DEFAULT 5000;
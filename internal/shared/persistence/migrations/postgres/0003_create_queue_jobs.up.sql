@@ -0,0 +1,12 @@
+-- jobs of the postgres queue backend
+CREATE TABLE IF NOT EXISTS queue_jobs (
+    id               BIGSERIAL PRIMARY KEY,
+    queue            TEXT NOT NULL,
+    body             BYTEA NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    run_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS queue_jobs_queue_run_at_idx ON queue_jobs (queue, run_at);
@@ -0,0 +1 @@
+ALTER TABLE queue_jobs DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,2 @@
+-- message properties and headers of the postgres queue backend
+ALTER TABLE queue_jobs ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+-- delivery lease, a consumer owns an in_flight event until lease_expires_at
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE webhook_events RENAME COLUMN tries TO trie;
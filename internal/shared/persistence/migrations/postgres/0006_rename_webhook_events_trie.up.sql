@@ -0,0 +1,2 @@
+-- WebhookEvent.Tries maps to "tries", with "trie" retries were never persisted
+ALTER TABLE webhook_events RENAME COLUMN trie TO tries;
@@ -0,0 +1,2 @@
+-- optimistic concurrency: every update checks and bumps the version
+ALTER TABLE webhook_events ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
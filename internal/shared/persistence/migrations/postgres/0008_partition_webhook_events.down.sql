@@ -0,0 +1,32 @@
+-- archived partitions are gone, only the rows still in the database return
+CREATE TABLE webhook_events_unpartitioned (
+    id               VARCHAR(26) PRIMARY KEY,
+    webhook_id       INTEGER NOT NULL REFERENCES webhooks(id),
+    event_type       TEXT NOT NULL,
+    payload          JSONB NOT NULL,
+    last_error       JSONB,
+    response_body    JSONB,
+    response_code    INTEGER,
+    tries            INTEGER NOT NULL DEFAULT 0,
+    status           TEXT NOT NULL,
+    lease_owner      TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    failed_at        TIMESTAMPTZ,
+    delivered_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    version          INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO webhook_events_unpartitioned (
+    id, webhook_id, event_type, payload, last_error, response_body, response_code, tries, status,
+    lease_owner, lease_expires_at, failed_at, delivered_at, created_at, updated_at, version
+)
+SELECT
+    id, webhook_id, event_type, payload, last_error, response_body, response_code, tries, status,
+    lease_owner, lease_expires_at, failed_at, delivered_at, created_at, updated_at, version
+FROM webhook_events;
+
+DROP TABLE webhook_events;
+ALTER TABLE webhook_events_unpartitioned RENAME TO webhook_events;
+ALTER TABLE webhook_events RENAME CONSTRAINT webhook_events_unpartitioned_pkey TO webhook_events_pkey;
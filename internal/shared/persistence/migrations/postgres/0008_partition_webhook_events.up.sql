@@ -0,0 +1,51 @@
+-- webhook_events becomes partitioned by created_at month, the primary key
+-- has to include the partition key. Partitions up to 3 months ahead are
+-- created here, later ones by the maintenance job.
+ALTER TABLE webhook_events RENAME TO webhook_events_legacy;
+ALTER TABLE webhook_events_legacy RENAME CONSTRAINT webhook_events_pkey TO webhook_events_legacy_pkey;
+
+CREATE TABLE webhook_events (
+    id               VARCHAR(26) NOT NULL,
+    webhook_id       INTEGER NOT NULL REFERENCES webhooks(id),
+    event_type       TEXT NOT NULL,
+    payload          JSONB NOT NULL,
+    last_error       JSONB,
+    response_body    JSONB,
+    response_code    INTEGER,
+    tries            INTEGER NOT NULL DEFAULT 0,
+    status           TEXT NOT NULL,
+    lease_owner      TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    failed_at        TIMESTAMPTZ,
+    delivered_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    version          INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+DO $$
+DECLARE
+    month DATE := date_trunc('month', COALESCE((SELECT MIN(created_at) FROM webhook_events_legacy), NOW()) AT TIME ZONE 'UTC');
+BEGIN
+    WHILE month < date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '3 months' LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF webhook_events FOR VALUES FROM (%L) TO (%L)',
+            'webhook_events_p' || to_char(month, 'YYYYMM'),
+            month::timestamp AT TIME ZONE 'UTC',
+            (month + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO webhook_events (
+    id, webhook_id, event_type, payload, last_error, response_body, response_code, tries, status,
+    lease_owner, lease_expires_at, failed_at, delivered_at, created_at, updated_at, version
+)
+SELECT
+    id, webhook_id, event_type, payload, last_error, response_body, response_code, tries, status,
+    lease_owner, lease_expires_at, failed_at, delivered_at, created_at, updated_at, version
+FROM webhook_events_legacy;
+
+DROP TABLE webhook_events_legacy;
@@ -0,0 +1,12 @@
+-- envelope encryption: one data key per scope (e.g. webhook:42), stored
+-- wrapped by the master key it names
+CREATE TABLE data_keys (
+    id            BIGSERIAL PRIMARY KEY,
+    scope         TEXT NOT NULL UNIQUE,
+    wrapped_key   BYTEA NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rotated_at    TIMESTAMPTZ
+);
+
+CREATE INDEX data_keys_master_key_id_idx ON data_keys (master_key_id);
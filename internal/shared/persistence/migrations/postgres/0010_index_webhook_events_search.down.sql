@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS webhook_events_payload_idx;
+DROP INDEX IF EXISTS webhook_events_status_updated_at_idx;
+DROP INDEX IF EXISTS webhook_events_response_code_created_at_idx;
+DROP INDEX IF EXISTS webhook_events_status_created_at_idx;
+DROP INDEX IF EXISTS webhook_events_event_type_created_at_idx;
+DROP INDEX IF EXISTS webhook_events_webhook_id_created_at_idx;
+DROP INDEX IF EXISTS webhook_events_created_at_id_idx;
@@ -0,0 +1,9 @@
+-- event listing is ordered by (created_at, id) and filtered by the columns
+-- below, the stuck event sweeper scans (status, updated_at)
+CREATE INDEX webhook_events_created_at_id_idx ON webhook_events (created_at DESC, id DESC);
+CREATE INDEX webhook_events_webhook_id_created_at_idx ON webhook_events (webhook_id, created_at DESC, id DESC);
+CREATE INDEX webhook_events_event_type_created_at_idx ON webhook_events (event_type, created_at DESC, id DESC);
+CREATE INDEX webhook_events_status_created_at_idx ON webhook_events (status, created_at DESC, id DESC);
+CREATE INDEX webhook_events_response_code_created_at_idx ON webhook_events (response_code, created_at DESC, id DESC);
+CREATE INDEX webhook_events_status_updated_at_idx ON webhook_events (status, updated_at);
+CREATE INDEX webhook_events_payload_idx ON webhook_events USING GIN (payload jsonb_path_ops);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS webhook_events_tenant_id_created_at_idx;
+ALTER TABLE webhook_events DROP CONSTRAINT IF EXISTS webhook_events_webhook_id_tenant_id_fkey;
+ALTER TABLE webhook_events DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS webhooks_tenant_id_idx;
+ALTER TABLE webhooks DROP CONSTRAINT IF EXISTS webhooks_id_tenant_id_key;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
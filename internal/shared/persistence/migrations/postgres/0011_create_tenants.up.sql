@@ -0,0 +1,25 @@
+-- tenants (applications) own webhooks, events carry the tenant of their
+-- webhook so every query is scoped without a join. Existing rows move to
+-- the default tenant, the column defaults keep producers that don't know
+-- about tenants working.
+CREATE TABLE tenants (
+    id             TEXT PRIMARY KEY,
+    name           TEXT NOT NULL,
+    status         TEXT NOT NULL DEFAULT 'active',
+    settings       JSONB NOT NULL DEFAULT '{}',
+    api_token_hash TEXT UNIQUE,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default');
+
+ALTER TABLE webhooks ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE webhooks ADD CONSTRAINT webhooks_id_tenant_id_key UNIQUE (id, tenant_id);
+CREATE INDEX webhooks_tenant_id_idx ON webhooks (tenant_id);
+
+-- the event and its webhook can't belong to different tenants
+ALTER TABLE webhook_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE webhook_events ADD CONSTRAINT webhook_events_webhook_id_tenant_id_fkey
+    FOREIGN KEY (webhook_id, tenant_id) REFERENCES webhooks (id, tenant_id);
+CREATE INDEX webhook_events_tenant_id_created_at_idx ON webhook_events (tenant_id, created_at DESC, id DESC);
@@ -0,0 +1,58 @@
+-- every HTTP call of a delivery is kept as an attempt, partitioned by
+-- created_at month like webhook_events and dropped by the maintenance job.
+-- The rollup job aggregates attempts into minute, hour and day buckets.
+ALTER TABLE webhooks ADD COLUMN slo_target DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE delivery_attempts (
+    id            BIGINT GENERATED ALWAYS AS IDENTITY,
+    event_id      VARCHAR(26) NOT NULL,
+    webhook_id    INTEGER NOT NULL,
+    tenant_id     TEXT NOT NULL,
+    attempt       INTEGER NOT NULL,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    outcome       TEXT NOT NULL,
+    duration_ms   INTEGER NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, created_at),
+    FOREIGN KEY (webhook_id, tenant_id) REFERENCES webhooks (id, tenant_id)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX delivery_attempts_created_at_idx ON delivery_attempts (created_at);
+
+DO $$
+DECLARE
+    month DATE := date_trunc('month', NOW() AT TIME ZONE 'UTC');
+BEGIN
+    WHILE month < date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '3 months' LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF delivery_attempts FOR VALUES FROM (%L) TO (%L)',
+            'delivery_attempts_p' || to_char(month, 'YYYYMM'),
+            month::timestamp AT TIME ZONE 'UTC',
+            (month + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+CREATE TABLE webhook_delivery_stats (
+    webhook_id        INTEGER NOT NULL,
+    tenant_id         TEXT NOT NULL,
+    granularity       TEXT NOT NULL,
+    bucket_start      TIMESTAMPTZ NOT NULL,
+    attempts          BIGINT NOT NULL DEFAULT 0,
+    successes         BIGINT NOT NULL DEFAULT 0,
+    failures_4xx      BIGINT NOT NULL DEFAULT 0,
+    failures_5xx      BIGINT NOT NULL DEFAULT 0,
+    failures_timeout  BIGINT NOT NULL DEFAULT 0,
+    failures_network  BIGINT NOT NULL DEFAULT 0,
+    failures_other    BIGINT NOT NULL DEFAULT 0,
+    latency_p50_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_p95_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_p99_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_histogram JSONB NOT NULL DEFAULT '[]',
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (webhook_id, granularity, bucket_start),
+    FOREIGN KEY (webhook_id, tenant_id) REFERENCES webhooks (id, tenant_id)
+);
+
+CREATE INDEX webhook_delivery_stats_tenant_id_idx ON webhook_delivery_stats (tenant_id, granularity, bucket_start);
@@ -0,0 +1,3 @@
+-- rows still waiting for their month partition are dropped with it
+DROP TABLE IF EXISTS delivery_attempts_default;
+DROP TABLE IF EXISTS webhook_events_default;
@@ -0,0 +1,5 @@
+-- rows whose created_at month has no partition yet land in the default
+-- partition instead of failing the insert, the maintenance job moves them
+-- to their month once it creates it.
+CREATE TABLE webhook_events_default PARTITION OF webhook_events DEFAULT;
+CREATE TABLE delivery_attempts_default PARTITION OF delivery_attempts DEFAULT;
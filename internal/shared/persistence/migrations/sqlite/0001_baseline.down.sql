@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS data_keys;
+DROP TABLE IF EXISTS webhook_events;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,46 @@
+-- sqlite schema for single binary deployments, it matches the postgres
+-- schema at 0010 without partitions: arrays and JSON are stored as text
+CREATE TABLE webhooks (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscribed_events TEXT NOT NULL DEFAULT '[]',
+    callback_url      TEXT NOT NULL,
+    secret            TEXT NOT NULL,
+    status            TEXT NOT NULL,
+    timeout_ms        INTEGER NOT NULL DEFAULT 5000,
+    failure_count     INTEGER NOT NULL DEFAULT 0,
+    last_failure_at   TIMESTAMP,
+    created_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE webhook_events (
+    id               VARCHAR(26) PRIMARY KEY,
+    webhook_id       INTEGER NOT NULL REFERENCES webhooks(id),
+    event_type       TEXT NOT NULL,
+    payload          TEXT NOT NULL,
+    last_error       TEXT,
+    response_body    TEXT,
+    response_code    INTEGER,
+    tries            INTEGER NOT NULL DEFAULT 0,
+    status           TEXT NOT NULL,
+    lease_owner      TEXT,
+    lease_expires_at TIMESTAMP,
+    failed_at        TIMESTAMP,
+    delivered_at     TIMESTAMP,
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    version          INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX webhook_events_created_at_id_idx ON webhook_events (created_at DESC, id DESC);
+CREATE INDEX webhook_events_webhook_id_created_at_idx ON webhook_events (webhook_id, created_at DESC, id DESC);
+CREATE INDEX webhook_events_status_updated_at_idx ON webhook_events (status, updated_at);
+
+CREATE TABLE data_keys (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope         TEXT NOT NULL UNIQUE,
+    wrapped_key   BLOB NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at    TIMESTAMP
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS webhook_events_tenant_id_created_at_idx;
+ALTER TABLE webhook_events DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS webhooks_tenant_id_idx;
+ALTER TABLE webhooks DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,19 @@
+-- see postgres/0011_create_tenants, sqlite can't add a column referencing
+-- another table with a default so the tenant references are not enforced
+CREATE TABLE tenants (
+    id             TEXT PRIMARY KEY,
+    name           TEXT NOT NULL,
+    status         TEXT NOT NULL DEFAULT 'active',
+    settings       TEXT NOT NULL DEFAULT '{}',
+    api_token_hash TEXT UNIQUE,
+    created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default');
+
+ALTER TABLE webhooks ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX webhooks_tenant_id_idx ON webhooks (tenant_id);
+
+ALTER TABLE webhook_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX webhook_events_tenant_id_created_at_idx ON webhook_events (tenant_id, created_at DESC, id DESC);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webhook_delivery_stats;
+DROP TABLE IF EXISTS delivery_attempts;
+
+ALTER TABLE webhooks DROP COLUMN slo_target;
@@ -0,0 +1,38 @@
+-- see postgres/0012_create_delivery_stats, attempts are not partitioned
+ALTER TABLE webhooks ADD COLUMN slo_target REAL NOT NULL DEFAULT 0;
+
+CREATE TABLE delivery_attempts (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id      VARCHAR(26) NOT NULL,
+    webhook_id    INTEGER NOT NULL,
+    tenant_id     TEXT NOT NULL,
+    attempt       INTEGER NOT NULL,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    outcome       TEXT NOT NULL,
+    duration_ms   INTEGER NOT NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX delivery_attempts_created_at_idx ON delivery_attempts (created_at);
+
+CREATE TABLE webhook_delivery_stats (
+    webhook_id        INTEGER NOT NULL,
+    tenant_id         TEXT NOT NULL,
+    granularity       TEXT NOT NULL,
+    bucket_start      TIMESTAMP NOT NULL,
+    attempts          INTEGER NOT NULL DEFAULT 0,
+    successes         INTEGER NOT NULL DEFAULT 0,
+    failures_4xx      INTEGER NOT NULL DEFAULT 0,
+    failures_5xx      INTEGER NOT NULL DEFAULT 0,
+    failures_timeout  INTEGER NOT NULL DEFAULT 0,
+    failures_network  INTEGER NOT NULL DEFAULT 0,
+    failures_other    INTEGER NOT NULL DEFAULT 0,
+    latency_p50_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_p95_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_p99_ms    INTEGER NOT NULL DEFAULT 0,
+    latency_histogram TEXT NOT NULL DEFAULT '[]',
+    updated_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (webhook_id, granularity, bucket_start)
+);
+
+CREATE INDEX webhook_delivery_stats_tenant_id_idx ON webhook_delivery_stats (tenant_id, granularity, bucket_start);
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Verify checks that every column GORM maps for models exists in the
+// migrated schema, so a drift like a misspelled column fails loudly instead
+// of silently dropping writes.
+func Verify(db *gorm.DB, models ...interface{}) error {
+	var errs []error
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+
+		if !db.Migrator().HasTable(model) {
+			errs = append(errs, fmt.Errorf("%s: table is missing", table))
+			continue
+		}
+
+		columnTypes, err := db.Migrator().ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		columns := map[string]bool{}
+		for _, column := range columnTypes {
+			columns[column.Name()] = true
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			if !columns[field.DBName] {
+				errs = append(errs, fmt.Errorf("%s: column %s of %s.%s is missing",
+					table, field.DBName, stmt.Schema.Name, field.Name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
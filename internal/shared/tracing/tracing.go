@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	env "github.com/webhook-processor/internal/shared/env"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/webhook-processor"
+
+const (
+	EXPORTER_OTLP = "otlp"
+	EXPORTER_NONE = "none"
+)
+
+// propagator is used whether or not Setup ran, the trace context a message
+// arrived with is forwarded even when this process does not trace
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// TracingOpts configures the exporter, the OTLP endpoint, headers and the
+// sampler are read by the SDK from the standard OTEL_* variables.
+type TracingOpts struct {
+	// Exporter is otlp or none, with none spans are still created so the
+	// trace context is propagated but nothing is exported
+	Exporter    string
+	ServiceName string
+}
+
+func TracingOptsFromEnv(serviceName string) TracingOpts {
+	return TracingOpts{
+		Exporter:    env.GetEnvOrDefault("OTEL_TRACES_EXPORTER", EXPORTER_NONE),
+		ServiceName: env.GetEnvOrDefault("OTEL_SERVICE_NAME", serviceName),
+	}
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator, shutdown flushes the spans not exported yet. Tests can install
+// a provider with an in-memory tracetest.SpanRecorder instead.
+func Setup(ctx context.Context, opts TracingOpts) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagator)
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	providerOpts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	switch opts.Exporter {
+	case EXPORTER_OTLP:
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+	case EXPORTER_NONE, "":
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", opts.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(providerOpts...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// Inject writes the trace context of ctx into headers (traceparent,
+// tracestate).
+func Inject(ctx context.Context, headers map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// Extract returns ctx with the remote span context of a W3C traceparent,
+// ctx is returned as is when traceparent is empty or invalid.
+func Extract(ctx context.Context, traceparent string, tracestate string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{
+		"traceparent": traceparent,
+		"tracestate":  tracestate,
+	})
+}
+
+// End records err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+type AuditHandler struct {
+	service ports.AuditQueryPort
+}
+
+func NewAuditHandler(service ports.AuditQueryPort) *AuditHandler {
+	return &AuditHandler{service: service}
+}
+
+func (h *AuditHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /audit-log", h.list)
+}
+
+// list answers GET /audit-log?actor=&action=&target_type=&target_id=&from=
+// &to=&cursor=&limit= with the entries of the tenant, newest first
+func (h *AuditHandler) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.service.ListAuditEntries(r.Context(), filter)
+	if errors.Is(err, model.ErrInvalidAuditFilter) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(r.Context(), "Error listing audit entries", "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func parseAuditFilter(query url.Values) (model.AuditFilter, error) {
+	filter := model.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     model.AuditAction(query.Get("action")),
+		TargetType: query.Get("target_type"),
+		TargetId:   query.Get("target_id"),
+	}
+
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid from %q, expected RFC 3339", value)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid to %q, expected RFC 3339", value)
+		}
+	}
+	if value := query.Get("cursor"); value != "" {
+		if filter.BeforeId, err = model.DecodeAuditCursor(value); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
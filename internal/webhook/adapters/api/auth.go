@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+// HEADER_TENANT_ID picks the tenant of a request made with the admin token
+const HEADER_TENANT_ID = "X-Tenant-Id"
+
+type TenantAuthenticator interface {
+	// GetTenantByAPIToken returns nil when no tenant owns token
+	GetTenantByAPIToken(ctx context.Context, token string) (*model.Tenant, error)
+}
+
+// RequireTenant scopes every request to a tenant, there is no way to read
+// across tenants. "Authorization: Bearer <token>" is either the API token
+// of a tenant or adminToken, the admin names the tenant in X-Tenant-Id. An
+// empty adminToken disables admin access.
+func RequireTenant(tenants TenantAuthenticator, adminToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || given == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(given), []byte(adminToken)) == 1 {
+			tenantId := r.Header.Get(HEADER_TENANT_ID)
+			if tenantId == "" {
+				writeError(w, http.StatusBadRequest, errors.New("missing "+HEADER_TENANT_ID+" header"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(model.WithTenant(r.Context(), tenantId)))
+			return
+		}
+
+		tenant, err := tenants.GetTenantByAPIToken(r.Context(), given)
+		if err != nil {
+			log.ErrorContext(r.Context(), "Error authenticating tenant", "err", err)
+			writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+			return
+		}
+		if tenant == nil || !tenant.IsActive() {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(model.WithTenant(r.Context(), tenant.Id)))
+	})
+}
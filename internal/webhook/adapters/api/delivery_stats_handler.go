@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+// DEFAULT_STATS_RANGE is the range of the stats of a request without from
+const DEFAULT_STATS_RANGE = 24 * time.Hour
+
+type DeliveryStatsHandler struct {
+	service ports.DeliveryStatsQueryPort
+}
+
+func NewDeliveryStatsHandler(service ports.DeliveryStatsQueryPort) *DeliveryStatsHandler {
+	return &DeliveryStatsHandler{service: service}
+}
+
+func (h *DeliveryStatsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /webhooks/{id}/stats", h.stats)
+	mux.HandleFunc("GET /webhooks/{id}/slo", h.slo)
+}
+
+// stats answers GET /webhooks/{id}/stats?granularity=&from=&to=, the hour
+// buckets of the last day by default
+func (h *DeliveryStatsHandler) stats(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeliveryStatsFilter(r.PathValue("id"), r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stats, err := h.service.ListDeliveryStats(r.Context(), filter)
+	if errors.Is(err, model.ErrInvalidDeliveryStatsFilter) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, model.ErrUnknownWebhook) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(r.Context(), "Error listing delivery stats", "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"webhook_id":  filter.WebhookId,
+		"granularity": filter.Granularity,
+		"from":        filter.From,
+		"to":          filter.To,
+		"buckets":     stats,
+	})
+}
+
+// slo answers GET /webhooks/{id}/slo with the success rate and burn rate of
+// every SLO window
+func (h *DeliveryStatsHandler) slo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid webhook id %q", r.PathValue("id")))
+		return
+	}
+
+	report, err := h.service.GetSLOReport(r.Context(), id)
+	if errors.Is(err, model.ErrUnknownWebhook) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(r.Context(), "Error getting SLO report", "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+func parseDeliveryStatsFilter(id string, query url.Values, now time.Time) (model.DeliveryStatsFilter, error) {
+	filter := model.DeliveryStatsFilter{
+		Granularity: model.StatsGranularityHour,
+		To:          now,
+	}
+
+	var err error
+	if filter.WebhookId, err = strconv.Atoi(id); err != nil {
+		return filter, fmt.Errorf("invalid webhook id %q", id)
+	}
+	if value := query.Get("granularity"); value != "" {
+		filter.Granularity = model.StatsGranularity(value)
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid to %q, expected RFC 3339", value)
+		}
+	}
+	filter.From = filter.To.Add(-DEFAULT_STATS_RANGE)
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid from %q, expected RFC 3339", value)
+		}
+	}
+
+	return filter, nil
+}
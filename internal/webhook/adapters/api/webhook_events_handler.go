@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+// PAYLOAD_FILTER_PREFIX marks query parameters matching payload fields,
+// e.g. payload.customer.id=42
+const PAYLOAD_FILTER_PREFIX = "payload."
+
+type WebhookEventsHandler struct {
+	service ports.WebhookQueryPort
+}
+
+func NewWebhookEventsHandler(service ports.WebhookQueryPort) *WebhookEventsHandler {
+	return &WebhookEventsHandler{service: service}
+}
+
+func (h *WebhookEventsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /webhook-events", h.list)
+	mux.HandleFunc("GET /webhook-events/{id}", h.get)
+}
+
+// list answers GET /webhook-events?webhook_id=&event_type=&status=&from=
+// &to=&response_code=&payload.<path>=&cursor=&limit=
+func (h *WebhookEventsHandler) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseWebhookEventFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.service.ListWebhookEvents(r.Context(), filter)
+	if errors.Is(err, model.ErrInvalidWebhookEventFilter) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(r.Context(), "Error listing webhook events", "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *WebhookEventsHandler) get(w http.ResponseWriter, r *http.Request) {
+	event, err := h.service.GetWebhookEvent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.ErrorContext(r.Context(), "Error getting webhook event", "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+	if event == nil {
+		writeError(w, http.StatusNotFound, errors.New("webhook event not found"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, event)
+}
+
+func parseWebhookEventFilter(query url.Values) (model.WebhookEventFilter, error) {
+	filter := model.WebhookEventFilter{
+		EventType:     query.Get("event_type"),
+		PayloadFields: map[string]interface{}{},
+	}
+
+	var err error
+	if value := query.Get("webhook_id"); value != "" {
+		if filter.WebhookId, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid webhook_id %q", value)
+		}
+	}
+	if value := query.Get("response_code"); value != "" {
+		if filter.ResponseCode, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid response_code %q", value)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		if filter.CreatedFrom, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid from %q, expected RFC 3339", value)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.CreatedTo, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, fmt.Errorf("invalid to %q, expected RFC 3339", value)
+		}
+	}
+	if value := query.Get("cursor"); value != "" {
+		if filter.Cursor, err = model.DecodeWebhookEventCursor(value); err != nil {
+			return filter, err
+		}
+	}
+
+	// status=failed,dead_letter and status=failed&status=dead_letter both work
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, model.WebhookEventsStatus(status))
+			}
+		}
+	}
+
+	for key, values := range query {
+		if !strings.HasPrefix(key, PAYLOAD_FILTER_PREFIX) || len(values) == 0 {
+			continue
+		}
+		filter.PayloadFields[strings.TrimPrefix(key, PAYLOAD_FILTER_PREFIX)] = payloadValue(values[0])
+	}
+
+	return filter, nil
+}
+
+// payloadValue reads JSON literals (numbers, booleans, quoted strings) and
+// falls back to the raw string, so order_id=abc and amount=42 both work.
+func payloadValue(raw string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return raw
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error("Error writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+
+	env "github.com/webhook-processor/internal/shared/env"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/gorm"
+)
+
+type BackendOpts struct {
+	// Backend is one of rabbitmq, postgres, nats, redis or memory (in
+	// process, messages are lost on exit)
+	Backend           string
+	DB                *gorm.DB
+	RabbitMQDelayMode RabbitMQDelayMode
+	NatsURL           string
+	RedisAddr         string
+	// ConsumerName identifies this process in backends that track consumers
+	ConsumerName string
+}
+
+func NewConnector(opts BackendOpts) (Connector, error) {
+	switch opts.Backend {
+	case "postgres":
+		return NewPostgresQueue(opts.DB, &PostgresQueueOpts{
+			QueueName: wb_model.WEBHOOK_QUEUE,
+		}), nil
+	case "nats":
+		return NewNatsQueue(&NatsQueueOpts{
+			URL:        opts.NatsURL,
+			StreamName: wb_model.WEBHOOK_STREAM,
+			Subject:    wb_model.ROUTING_KEY,
+			Durable:    wb_model.WEBHOOK_QUEUE,
+		}), nil
+	case "redis":
+		return NewRedisQueue(&RedisQueueOpts{
+			Addr:     opts.RedisAddr,
+			Stream:   wb_model.WEBHOOK_QUEUE,
+			Group:    wb_model.WEBHOOK_QUEUE,
+			Consumer: opts.ConsumerName,
+		}), nil
+	case "memory":
+		return NewMemoryQueue(&MemoryQueueOpts{}), nil
+	case "rabbitmq":
+		if err := opts.RabbitMQDelayMode.Validate(); err != nil {
+			return nil, err
+		}
+		return NewRabbitMQConnector(&RabbitMQConnOpts{
+			QueueName:    wb_model.WEBHOOK_QUEUE,
+			ExchangeName: wb_model.EXCHANGE_NAME,
+			RoutingKey:   wb_model.ROUTING_KEY,
+			DelayMode:    opts.RabbitMQDelayMode,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown queue backend %q", opts.Backend)
+}
+
+func BackendOptsFromEnv(db *gorm.DB) BackendOpts {
+	hostname, _ := os.Hostname()
+	return BackendOpts{
+		Backend:           env.GetEnvOrDefault("QUEUE_BACKEND", "rabbitmq"),
+		DB:                db,
+		RabbitMQDelayMode: RabbitMQDelayMode(env.GetEnvOrDefault("RABBITMQ_DELAY_MODE", string(DelayModePlugin))),
+		NatsURL:           env.GetEnvOrDefault("NATS_URL", "nats://localhost:4222"),
+		RedisAddr:         env.GetEnvOrDefault("REDIS_ADDR", "localhost:6379"),
+		ConsumerName:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
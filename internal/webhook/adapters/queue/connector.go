@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+// Connector is implemented by every queue backend the consumer can run on,
+// deliveries are exposed as amqp.Delivery so RabbitMQConsumer works as is.
+type Connector interface {
+	ports.QueuePort
+	Parker
+	Listen() <-chan amqp.Delivery
+	// Ping reports whether the backend can still publish and deliver
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Connector = (*RabbitMQConnector)(nil)
+	_ Connector = (*MemoryQueue)(nil)
+	_ Connector = (*PostgresQueue)(nil)
+	_ Connector = (*NatsQueue)(nil)
+	_ Connector = (*RedisQueue)(nil)
+
+	_ ParkingAdmin = (*RabbitMQConnector)(nil)
+	_ ParkingAdmin = (*MemoryQueue)(nil)
+	_ ParkingAdmin = (*PostgresQueue)(nil)
+	_ ParkingAdmin = (*RedisQueue)(nil)
+	_ ParkingAdmin = (*NatsQueue)(nil)
+)
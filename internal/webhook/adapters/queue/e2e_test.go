@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webhook-processor/internal/shared/clock"
+	shttp "github.com/webhook-processor/internal/shared/http"
+	"github.com/webhook-processor/internal/webhook/adapters/repo"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/domain/service"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"gorm.io/datatypes"
+)
+
+// TestDeliveryRetriedUntilSuccess publishes an event to a receiver failing
+// twice, the fake clock releases the delayed retries.
+func TestDeliveryRetriedUntilSuccess(t *testing.T) {
+	var hits int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer receiver.Close()
+
+	fc := clock.NewFakeClock(time.Now())
+	q := NewMemoryQueue(&MemoryQueueOpts{Clock: fc})
+	defer q.Close()
+
+	r := repo.NewMemoryWebhookRepo()
+	r.SaveWebhook(model.Webhook{Id: 1, CallbackURL: receiver.URL, Status: model.WebhookStatusActive, Secret: "secret"})
+	r.SaveWebhookEvent(model.WebhookEvent{
+		Id:        "event-1",
+		WebhookId: 1,
+		Status:    model.WebhookEventsStatusPending,
+		Payload:   datatypes.NewJSONType(model.Object{"hello": "world"}),
+	})
+
+	svc := service.NewWebhookService(r, nil, shttp.NewClient(shttp.ClientOpts{Timeout: time.Second}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewRabbitMQConsumer(svc, q).Run(ctx, q.Listen(), 1)
+
+	if err := q.Publish(ctx, []byte(`{"id":"event-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		fc.Advance(time.Second)
+
+		event, err := r.GetWebhookEventByID(model.WithTenant(ctx, model.DEFAULT_TENANT_ID), "event-1")
+		if err != nil {
+			t.Fatalf("get event: %v", err)
+		}
+		if event.Status != model.WebhookEventsStatusDelivered {
+			continue
+		}
+		if event.Tries != 3 {
+			t.Errorf("tries = %d, want 3", event.Tries)
+		}
+		if got := atomic.LoadInt32(&hits); got != 3 {
+			t.Errorf("receiver hit %d times, want 3", got)
+		}
+		return
+	}
+	t.Fatalf("event not delivered after %d attempts", atomic.LoadInt32(&hits))
+}
+
+// TestDeliveryFailsAfterMaxAttempts publishes an event to a receiver that
+// never recovers, the event must fail after the last attempt and stop being
+// retried.
+func TestDeliveryFailsAfterMaxAttempts(t *testing.T) {
+	var hits int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	fc := clock.NewFakeClock(time.Now())
+	q := NewMemoryQueue(&MemoryQueueOpts{Clock: fc})
+	defer q.Close()
+
+	r := repo.NewMemoryWebhookRepo()
+	r.SaveWebhook(model.Webhook{Id: 1, CallbackURL: receiver.URL, Status: model.WebhookStatusActive, Secret: "secret"})
+	r.SaveWebhookEvent(model.WebhookEvent{
+		Id:        "event-1",
+		WebhookId: 1,
+		Status:    model.WebhookEventsStatusPending,
+		Payload:   datatypes.NewJSONType(model.Object{"hello": "world"}),
+	})
+
+	svc := service.NewWebhookService(r, nil, shttp.NewClient(shttp.ClientOpts{Timeout: time.Second}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewRabbitMQConsumer(svc, q).Run(ctx, q.Listen(), 1)
+
+	if err := q.Publish(ctx, []byte(`{"id":"event-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	var event *model.WebhookEvent
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		fc.Advance(time.Minute)
+
+		var err error
+		event, err = r.GetWebhookEventByID(model.WithTenant(ctx, model.DEFAULT_TENANT_ID), "event-1")
+		if err != nil {
+			t.Fatalf("get event: %v", err)
+		}
+		if event.Status == model.WebhookEventsStatusFailed && q.Len() == 0 {
+			break
+		}
+	}
+
+	if event.Status != model.WebhookEventsStatusFailed || event.FailedAt.IsZero() {
+		t.Fatalf("event %s failed at %v after %d attempts, want failed", event.Status, event.FailedAt, atomic.LoadInt32(&hits))
+	}
+	if event.Tries != model.MAX_WEBHOOK_SEND_ATTEMPTS {
+		t.Errorf("tries = %d, want %d", event.Tries, model.MAX_WEBHOOK_SEND_ATTEMPTS)
+	}
+
+	// later retries would be released by the clock
+	for range 5 {
+		fc.Advance(time.Hour)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&hits); got != model.MAX_WEBHOOK_SEND_ATTEMPTS {
+		t.Errorf("receiver hit %d times, want %d", got, model.MAX_WEBHOOK_SEND_ATTEMPTS)
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("%d messages left in the queue, want none", n)
+	}
+	if parked, _ := q.ListParked(ctx, 0); len(parked) != 0 {
+		t.Errorf("%d messages parked, want none", len(parked))
+	}
+}
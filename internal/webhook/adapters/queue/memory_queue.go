@@ -0,0 +1,276 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/webhook-processor/internal/shared/clock"
+	"github.com/webhook-processor/internal/shared/tracing"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+var ErrMemoryQueueClosed = errors.New("memory queue is closed")
+
+// MemoryQueue is an in-process QueuePort, messages are handed out as
+// amqp.Delivery values so RabbitMQConsumer can consume them unchanged.
+type MemoryQueue struct {
+	mu         sync.Mutex
+	clock      clock.Clock
+	scheduled  []memoryMessage
+	unacked    map[uint64]memoryMessage
+	nextTag    uint64
+	parked     []ParkedMessage
+	deliveries chan amqp.Delivery
+	wake       chan struct{}
+	done       chan struct{}
+	listenOnce sync.Once
+	closeOnce  sync.Once
+}
+
+type memoryMessage struct {
+	body        []byte
+	metadata    ports.QueueMessageMetadata
+	due         time.Time
+	redelivered bool
+}
+
+type MemoryQueueOpts struct {
+	Clock clock.Clock
+}
+
+func NewMemoryQueue(opts *MemoryQueueOpts) *MemoryQueue {
+	c := opts.Clock
+	if c == nil {
+		c = clock.NewRealClock()
+	}
+
+	return &MemoryQueue{
+		clock:      c,
+		unacked:    map[uint64]memoryMessage{},
+		deliveries: make(chan amqp.Delivery),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) (err error) {
+	_, span := startPublishSpan(ctx, "memory", "memory", &opts)
+	defer func() { tracing.End(span, err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-q.done:
+		return ErrMemoryQueueClosed
+	default:
+	}
+
+	body := make([]byte, len(msg))
+	copy(body, msg)
+
+	q.mu.Lock()
+	q.schedule(memoryMessage{
+		body:     body,
+		metadata: opts.Metadata,
+		due:      q.clock.Now().Add(time.Duration(opts.Delay) * time.Millisecond),
+	})
+	q.mu.Unlock()
+
+	q.notify()
+	return nil
+}
+
+func (q *MemoryQueue) Listen() <-chan amqp.Delivery {
+	q.listenOnce.Do(func() {
+		go q.dispatch()
+	})
+	return q.deliveries
+}
+
+func (q *MemoryQueue) Ping(ctx context.Context) error {
+	select {
+	case <-q.done:
+		return ErrMemoryQueueClosed
+	default:
+		return nil
+	}
+}
+
+func (q *MemoryQueue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+	return nil
+}
+
+// Len returns the number of messages that are scheduled or delivered but
+// not yet acknowledged.
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.scheduled) + len(q.unacked)
+}
+
+func (q *MemoryQueue) Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.parked = append(q.parked, ParkedMessage{
+		Id:       opts.Metadata.Headers[HEADER_PARKING_ID],
+		Body:     msg.Body,
+		Metadata: opts.Metadata,
+	})
+	return nil
+}
+
+func (q *MemoryQueue) ListParked(ctx context.Context, limit int) ([]ParkedMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit <= 0 || limit > len(q.parked) {
+		limit = len(q.parked)
+	}
+	return append([]ParkedMessage{}, q.parked[:limit]...), nil
+}
+
+func (q *MemoryQueue) RequeueParked(ctx context.Context, id string) error {
+	q.mu.Lock()
+	var msg ParkedMessage
+	found := false
+	for i := range q.parked {
+		if q.parked[i].Id == id {
+			msg, found = q.parked[i], true
+			q.parked = append(q.parked[:i], q.parked[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	if !found {
+		return ErrParkedMessageNotFound
+	}
+	return q.Publish(ctx, msg.Body, unparkedOpts(msg.Metadata))
+}
+
+func (q *MemoryQueue) Ack(tag uint64, multiple bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, t := range q.tags(tag, multiple) {
+		delete(q.unacked, t)
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Nack(tag uint64, multiple bool, requeue bool) error {
+	q.mu.Lock()
+	for _, t := range q.tags(tag, multiple) {
+		msg := q.unacked[t]
+		delete(q.unacked, t)
+		if requeue {
+			msg.due = q.clock.Now()
+			msg.redelivered = true
+			q.schedule(msg)
+		}
+	}
+	q.mu.Unlock()
+
+	q.notify()
+	return nil
+}
+
+func (q *MemoryQueue) Reject(tag uint64, requeue bool) error {
+	return q.Nack(tag, false, requeue)
+}
+
+func (q *MemoryQueue) dispatch() {
+	defer close(q.deliveries)
+
+	for {
+		q.mu.Lock()
+		d, wait, ready := q.next()
+		q.mu.Unlock()
+
+		if ready {
+			select {
+			case q.deliveries <- d:
+			case <-q.done:
+				return
+			}
+			continue
+		}
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = q.clock.After(wait)
+		}
+
+		select {
+		case <-q.wake:
+		case <-timer:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// next pops the first due message and registers it as unacked, when
+// nothing is due it returns how long to wait for the next one.
+func (q *MemoryQueue) next() (amqp.Delivery, time.Duration, bool) {
+	if len(q.scheduled) == 0 {
+		return amqp.Delivery{}, 0, false
+	}
+
+	msg := q.scheduled[0]
+	if wait := msg.due.Sub(q.clock.Now()); wait > 0 {
+		return amqp.Delivery{}, wait, false
+	}
+
+	q.scheduled = q.scheduled[1:]
+	q.nextTag++
+	q.unacked[q.nextTag] = msg
+
+	return withMetadata(amqp.Delivery{
+		Acknowledger: q,
+		DeliveryTag:  q.nextTag,
+		Redelivered:  msg.redelivered,
+		ContentType:  "application/json",
+		Body:         msg.body,
+	}, msg.metadata), 0, true
+}
+
+func (q *MemoryQueue) schedule(msg memoryMessage) {
+	i := sort.Search(len(q.scheduled), func(i int) bool {
+		return q.scheduled[i].due.After(msg.due)
+	})
+	q.scheduled = append(q.scheduled, memoryMessage{})
+	copy(q.scheduled[i+1:], q.scheduled[i:])
+	q.scheduled[i] = msg
+}
+
+func (q *MemoryQueue) tags(tag uint64, multiple bool) []uint64 {
+	if !multiple {
+		return []uint64{tag}
+	}
+
+	tags := []uint64{}
+	for t := range q.unacked {
+		if t <= tag {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func (q *MemoryQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
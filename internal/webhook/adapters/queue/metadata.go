@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/webhook-processor/internal/shared/tracing"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startPublishSpan opens the producer span of a publish and writes its trace
+// context in the message headers, the headers map is copied first.
+func startPublishSpan(ctx context.Context, system string, destination string, opts *ports.QueuePortPublishOpts) (context.Context, trace.Span) {
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+destination,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.operation.type", "publish"),
+			attribute.String("messaging.destination.name", destination),
+			attribute.String("messaging.message.id", opts.Metadata.MessageId),
+			attribute.Int("messaging.message.delay_ms", opts.Delay),
+		),
+	)
+
+	headers := make(map[string]string, len(opts.Metadata.Headers)+2)
+	maps.Copy(headers, opts.Metadata.Headers)
+	tracing.Inject(ctx, headers)
+	opts.Metadata.Headers = headers
+
+	return ctx, span
+}
+
+func amqpHeaders(meta ports.QueueMessageMetadata) amqp.Table {
+	headers := amqp.Table{}
+	for key, value := range meta.Headers {
+		headers[key] = value
+	}
+	return headers
+}
+
+// withMetadata fills the AMQP properties of a delivery built by a non
+// RabbitMQ backend so the consumer sees the same message on every queue.
+func withMetadata(d amqp.Delivery, meta ports.QueueMessageMetadata) amqp.Delivery {
+	d.MessageId = meta.MessageId
+	d.Type = meta.Type
+	d.Timestamp = meta.Timestamp
+	d.Headers = amqpHeaders(meta)
+	return d
+}
+
+// decodeMessage reads the envelope from the body, fields missing there
+// (legacy bare {id} messages) are taken from the message properties. The
+// trace context is the exception, the headers win over the body.
+func decodeMessage(d amqp.Delivery) (model.WebhookEventMessage, error) {
+	msg, err := model.DecodeWebhookEventMessage(d.Body)
+	if err != nil {
+		return msg, err
+	}
+
+	if msg.WebhookId == 0 {
+		msg.WebhookId, _ = strconv.Atoi(headerString(d.Headers, ports.HEADER_WEBHOOK_ID))
+	}
+	if msg.Attempt == 0 {
+		msg.Attempt, _ = strconv.Atoi(headerString(d.Headers, ports.HEADER_ATTEMPT))
+	}
+	if msg.Attempt == 0 {
+		msg.Attempt = 1
+	}
+	if msg.EnqueuedAt.IsZero() {
+		msg.EnqueuedAt = d.Timestamp
+	}
+	if msg.EnqueuedAt.IsZero() {
+		msg.EnqueuedAt = time.Now().UTC()
+	}
+	// the trace context is read from the headers first, where brokers and
+	// instrumented publishers put it, the body only covers the messages
+	// published without them
+	if traceParent := headerString(d.Headers, ports.HEADER_TRACEPARENT); traceParent != "" {
+		msg.TraceParent = traceParent
+		msg.TraceState = headerString(d.Headers, ports.HEADER_TRACESTATE)
+	}
+	if msg.TenantId == "" {
+		msg.TenantId = headerString(d.Headers, ports.HEADER_TENANT_ID)
+	}
+	if msg.Producer == "" {
+		msg.Producer = headerString(d.Headers, ports.HEADER_PRODUCER)
+	}
+
+	return msg, nil
+}
+
+func headerString(headers amqp.Table, key string) string {
+	value, ok := headers[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
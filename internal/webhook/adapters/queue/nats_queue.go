@@ -0,0 +1,383 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/tracing"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+// NOT_BEFORE_HEADER carries the unix millis a delayed message may be
+// processed at, until then the consumer naks it with the remaining delay.
+const NOT_BEFORE_HEADER = "x-not-before"
+
+// JetStream has no message properties, they travel as headers. Nats-Msg-Id
+// is avoided on purpose: retries reuse the id and would be deduplicated.
+const (
+	MESSAGE_ID_HEADER = "x-message-id"
+	TYPE_HEADER       = "x-type"
+	TIMESTAMP_HEADER  = "x-timestamp"
+)
+
+var ErrNatsMessageNotInFlight = errors.New("nats message is not in flight")
+
+type NatsQueue struct {
+	nc         *nats.Conn
+	js         jetstream.JetStream
+	consumer   jetstream.Consumer
+	opts       *NatsQueueOpts
+	deliveries chan amqp.Delivery
+	ctx        context.Context
+	cancel     context.CancelFunc
+	listenOnce sync.Once
+
+	mu       sync.Mutex
+	inFlight map[uint64]natsInFlight
+	nextTag  uint64
+}
+
+// natsInFlight is a delivered message, its heartbeat runs until it is
+// acked or nacked.
+type natsInFlight struct {
+	msg  jetstream.Msg
+	done chan struct{}
+}
+
+type NatsQueueOpts struct {
+	URL        string
+	StreamName string
+	Subject    string
+	// ParkingSubject is part of the stream but has no consumer, parked
+	// messages stay there until requeued by the admin command
+	ParkingSubject string
+	Durable        string
+	// AckWait is how long a message may stay unacked before JetStream
+	// redelivers it, deliveries in progress are extended every half AckWait
+	// so it only bounds how fast a crashed consumer's messages come back.
+	AckWait time.Duration
+}
+
+func NewNatsQueue(opts *NatsQueueOpts) *NatsQueue {
+	if opts.AckWait <= 0 {
+		opts.AckWait = time.Minute
+	}
+	if opts.ParkingSubject == "" {
+		opts.ParkingSubject = opts.Subject + ".parking"
+	}
+
+	nc, err := nats.Connect(opts.URL, nats.Timeout(2*time.Second))
+	failOnError(err, "Failed to connect to NATS")
+
+	js, err := jetstream.New(nc)
+	failOnError(err, "Failed to open JetStream")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      opts.StreamName,
+		Subjects:  []string{opts.Subject, opts.ParkingSubject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	failOnError(err, "Failed to declare a stream")
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, opts.StreamName, jetstream.ConsumerConfig{
+		Durable:       opts.Durable,
+		FilterSubject: opts.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       opts.AckWait,
+	})
+	failOnError(err, "Failed to register a consumer")
+
+	return &NatsQueue{
+		nc:         nc,
+		js:         js,
+		consumer:   consumer,
+		opts:       opts,
+		deliveries: make(chan amqp.Delivery),
+		ctx:        ctx,
+		cancel:     cancel,
+		inFlight:   map[uint64]natsInFlight{},
+	}
+}
+
+func (q *NatsQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) (err error) {
+	ctx, span := startPublishSpan(ctx, "nats", q.opts.Subject, &opts)
+	defer func() { tracing.End(span, err) }()
+
+	m := nats.NewMsg(q.opts.Subject)
+	m.Data = msg
+	for key, value := range opts.Metadata.Headers {
+		m.Header.Set(key, value)
+	}
+	m.Header.Set(MESSAGE_ID_HEADER, opts.Metadata.MessageId)
+	m.Header.Set(TYPE_HEADER, opts.Metadata.Type)
+	if !opts.Metadata.Timestamp.IsZero() {
+		m.Header.Set(TIMESTAMP_HEADER, opts.Metadata.Timestamp.Format(time.RFC3339Nano))
+	}
+	if opts.Delay > 0 {
+		notBefore := time.Now().Add(time.Duration(opts.Delay) * time.Millisecond)
+		m.Header.Set(NOT_BEFORE_HEADER, strconv.FormatInt(notBefore.UnixMilli(), 10))
+	}
+
+	_, err = q.js.PublishMsg(ctx, m)
+	return err
+}
+
+func (q *NatsQueue) Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error {
+	m := nats.NewMsg(q.opts.ParkingSubject)
+	m.Data = msg.Body
+	for key, value := range opts.Metadata.Headers {
+		m.Header.Set(key, value)
+	}
+	m.Header.Set(MESSAGE_ID_HEADER, opts.Metadata.MessageId)
+	m.Header.Set(TYPE_HEADER, opts.Metadata.Type)
+
+	_, err := q.js.PublishMsg(ctx, m)
+	return err
+}
+
+// ListParked reads the parking subject in stream order without consuming
+// it, messages are fetched one at a time by sequence.
+func (q *NatsQueue) ListParked(ctx context.Context, limit int) ([]ParkedMessage, error) {
+	parked := []ParkedMessage{}
+	err := q.scanParked(ctx, func(msg *jetstream.RawStreamMsg) bool {
+		parked = append(parked, natsParkedMessage(msg))
+		return limit <= 0 || len(parked) < limit
+	})
+	return parked, err
+}
+
+func (q *NatsQueue) RequeueParked(ctx context.Context, id string) error {
+	var found *jetstream.RawStreamMsg
+	err := q.scanParked(ctx, func(msg *jetstream.RawStreamMsg) bool {
+		if natsParkedMessage(msg).Id == id {
+			found = msg
+		}
+		return found == nil
+	})
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return ErrParkedMessageNotFound
+	}
+
+	p := natsParkedMessage(found)
+	if err := q.Publish(ctx, p.Body, unparkedOpts(p.Metadata)); err != nil {
+		return err
+	}
+
+	stream, err := q.js.Stream(ctx, q.opts.StreamName)
+	if err != nil {
+		return err
+	}
+	return stream.DeleteMsg(ctx, found.Sequence)
+}
+
+// scanParked calls fn with the parked messages until it returns false.
+func (q *NatsQueue) scanParked(ctx context.Context, fn func(msg *jetstream.RawStreamMsg) bool) error {
+	stream, err := q.js.Stream(ctx, q.opts.StreamName)
+	if err != nil {
+		return err
+	}
+
+	for seq := uint64(1); ; {
+		msg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(q.opts.ParkingSubject))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(msg) {
+			return nil
+		}
+		seq = msg.Sequence + 1
+	}
+}
+
+func natsParkedMessage(msg *jetstream.RawStreamMsg) ParkedMessage {
+	metadata := natsMetadata(msg.Header)
+	return ParkedMessage{
+		Id:       metadata.Headers[HEADER_PARKING_ID],
+		Body:     msg.Data,
+		Metadata: metadata,
+	}
+}
+
+func (q *NatsQueue) Listen() <-chan amqp.Delivery {
+	q.listenOnce.Do(func() {
+		go q.fetch()
+	})
+	return q.deliveries
+}
+
+func (q *NatsQueue) Ping(ctx context.Context) error {
+	if status := q.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection %s", status)
+	}
+	return nil
+}
+
+func (q *NatsQueue) Close() error {
+	q.cancel()
+	return q.nc.Drain()
+}
+
+func (q *NatsQueue) Ack(tag uint64, multiple bool) error {
+	msg, err := q.take(tag)
+	if err != nil {
+		return err
+	}
+	return msg.Ack()
+}
+
+func (q *NatsQueue) Nack(tag uint64, multiple bool, requeue bool) error {
+	msg, err := q.take(tag)
+	if err != nil {
+		return err
+	}
+	if !requeue {
+		return msg.Term()
+	}
+	return msg.Nak()
+}
+
+func (q *NatsQueue) Reject(tag uint64, requeue bool) error {
+	return q.Nack(tag, false, requeue)
+}
+
+func (q *NatsQueue) fetch() {
+	defer close(q.deliveries)
+
+	for q.ctx.Err() == nil {
+		batch, err := q.consumer.Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+		if err != nil {
+			log.Error("Error fetching messages", "err", err)
+			select {
+			case <-time.After(time.Second):
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+
+		for msg := range batch.Messages() {
+			if delay := notBeforeDelay(msg); delay > 0 {
+				if err := msg.NakWithDelay(delay); err != nil {
+					log.Error("Error delaying message", "err", err)
+				}
+				continue
+			}
+
+			select {
+			case q.deliveries <- q.toDelivery(msg):
+			case <-q.ctx.Done():
+				msg.Nak()
+				return
+			}
+		}
+
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			log.Error("Error fetching messages", "err", err)
+		}
+	}
+}
+
+func (q *NatsQueue) toDelivery(msg jetstream.Msg) amqp.Delivery {
+	q.mu.Lock()
+	q.nextTag++
+	tag := q.nextTag
+	done := make(chan struct{})
+	q.inFlight[tag] = natsInFlight{msg: msg, done: done}
+	q.mu.Unlock()
+
+	go q.heartbeat(msg, done)
+
+	redelivered := false
+	if md, err := msg.Metadata(); err == nil {
+		redelivered = md.NumDelivered > 1
+	}
+
+	return withMetadata(amqp.Delivery{
+		Acknowledger: q,
+		DeliveryTag:  tag,
+		Redelivered:  redelivered,
+		ContentType:  "application/json",
+		Body:         msg.Data(),
+	}, natsMetadata(msg.Headers()))
+}
+
+func natsMetadata(header nats.Header) ports.QueueMessageMetadata {
+	metadata := ports.QueueMessageMetadata{
+		MessageId: header.Get(MESSAGE_ID_HEADER),
+		Type:      header.Get(TYPE_HEADER),
+		Headers:   map[string]string{},
+	}
+	metadata.Timestamp, _ = time.Parse(time.RFC3339Nano, header.Get(TIMESTAMP_HEADER))
+
+	for key := range header {
+		switch key {
+		case MESSAGE_ID_HEADER, TYPE_HEADER, TIMESTAMP_HEADER, NOT_BEFORE_HEADER:
+			continue
+		}
+		metadata.Headers[key] = header.Get(key)
+	}
+	return metadata
+}
+
+// heartbeat keeps a long delivery from being redelivered to another
+// consumer, it resets the AckWait of msg until done is closed.
+func (q *NatsQueue) heartbeat(msg jetstream.Msg, done chan struct{}) {
+	ticker := time.NewTicker(q.opts.AckWait / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := msg.InProgress(); err != nil {
+				log.Error("Error extending message ack wait", "err", err)
+			}
+		}
+	}
+}
+
+func (q *NatsQueue) take(tag uint64) (jetstream.Msg, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	inFlight, ok := q.inFlight[tag]
+	if !ok {
+		return nil, ErrNatsMessageNotInFlight
+	}
+	delete(q.inFlight, tag)
+	close(inFlight.done)
+	return inFlight.msg, nil
+}
+
+func notBeforeDelay(msg jetstream.Msg) time.Duration {
+	header := msg.Headers().Get(NOT_BEFORE_HEADER)
+	if header == "" {
+		return 0
+	}
+
+	notBefore, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Until(time.UnixMilli(notBefore))
+}
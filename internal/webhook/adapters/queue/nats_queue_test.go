@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	natstest "github.com/nats-io/nats-server/v2/test"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+// newTestNatsQueue starts an in-process JetStream server, every test gets
+// its own server and stream.
+func newTestNatsQueue(t *testing.T, ackWait time.Duration) *NatsQueue {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	server := natstest.RunServer(&opts)
+	t.Cleanup(server.Shutdown)
+
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	q := NewNatsQueue(&NatsQueueOpts{
+		URL:        server.ClientURL(),
+		StreamName: name,
+		Subject:    name + ".events",
+		Durable:    name,
+		AckWait:    ackWait,
+	})
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func TestNatsQueuePublishAck(t *testing.T) {
+	q := newTestNatsQueue(t, time.Second)
+	ctx := context.Background()
+
+	metadata := ports.QueueMessageMetadata{MessageId: "m-1", Type: "webhook_event", Headers: map[string]string{"x-tenant": "acme"}}
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{Metadata: metadata}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	msg := receive(t, q.Listen())
+	if string(msg.Body) != `{"id":"e-1"}` || msg.MessageId != "m-1" || msg.Type != "webhook_event" {
+		t.Fatalf("unexpected delivery %+v", msg)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := msg.Ack(false); err != ErrNatsMessageNotInFlight {
+		t.Fatalf("second ack = %v, want %v", err, ErrNatsMessageNotInFlight)
+	}
+}
+
+// TestNatsQueueHeartbeat holds a delivery for several AckWaits, the
+// heartbeat must keep JetStream from redelivering it.
+func TestNatsQueueHeartbeat(t *testing.T) {
+	q := newTestNatsQueue(t, time.Second)
+	ctx := context.Background()
+
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	msg := receive(t, q.Listen())
+
+	time.Sleep(3 * q.opts.AckWait)
+
+	info, err := q.consumer.Info(ctx)
+	if err != nil {
+		t.Fatalf("consumer info: %v", err)
+	}
+	if info.NumRedelivered != 0 {
+		t.Fatalf("message redelivered %d times while in progress", info.NumRedelivered)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+}
+
+func TestNatsQueueDelayedPublish(t *testing.T) {
+	q := newTestNatsQueue(t, time.Second)
+	ctx := context.Background()
+
+	published := time.Now()
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{Delay: 500}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	msg := receive(t, q.Listen())
+	if elapsed := time.Since(published); elapsed < 500*time.Millisecond {
+		t.Fatalf("delayed message delivered after %s", elapsed)
+	}
+	msg.Ack(false)
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case msg, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatal("no delivery")
+	}
+	return amqp.Delivery{}
+}
+
+func TestNatsQueueParking(t *testing.T) {
+	q := newTestNatsQueue(t, time.Second)
+	ctx := context.Background()
+
+	if err := q.Publish(ctx, []byte(`not json`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	deliveries := q.Listen()
+	msg := receive(t, deliveries)
+	if err := q.Park(ctx, msg, parkingOpts(msg, PARK_REASON_MALFORMED, nil, nil)); err != nil {
+		t.Fatalf("park: %v", err)
+	}
+	msg.Ack(false)
+
+	parked, err := q.ListParked(ctx, 10)
+	if err != nil {
+		t.Fatalf("list parked: %v", err)
+	}
+	if len(parked) != 1 || string(parked[0].Body) != `not json` || parked[0].Reason() != PARK_REASON_MALFORMED {
+		t.Fatalf("unexpected parked messages %+v", parked)
+	}
+
+	if err := q.RequeueParked(ctx, parked[0].Id); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if err := q.RequeueParked(ctx, parked[0].Id); err != ErrParkedMessageNotFound {
+		t.Fatalf("second requeue = %v, want %v", err, ErrParkedMessageNotFound)
+	}
+	requeued := receive(t, deliveries)
+	if string(requeued.Body) != `not json` {
+		t.Fatalf("unexpected requeued body %s", requeued.Body)
+	}
+	if _, ok := requeued.Headers[HEADER_PARKED_REASON]; ok {
+		t.Fatal("requeued message still carries the parking headers")
+	}
+	requeued.Ack(false)
+}
@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+const (
+	PARK_REASON_MALFORMED = "malformed"
+	PARK_REASON_PANIC     = "panic"
+)
+
+const (
+	HEADER_PARKING_ID           = "x-parking-id"
+	HEADER_PARKED_REASON        = "x-parked-reason"
+	HEADER_PARKED_ERROR         = "x-parked-error"
+	HEADER_PARKED_STACK         = "x-parked-stack"
+	HEADER_PARKED_AT            = "x-parked-at"
+	HEADER_ORIGINAL_EXCHANGE    = "x-original-exchange"
+	HEADER_ORIGINAL_ROUTING_KEY = "x-original-routing-key"
+)
+
+var ErrParkedMessageNotFound = errors.New("parked message not found")
+
+// Parker moves messages the consumer can't process to a parking queue
+// where they wait for an operator instead of being dropped.
+type Parker interface {
+	Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error
+}
+
+// ParkingAdmin is implemented by the backends the admin command supports.
+type ParkingAdmin interface {
+	ListParked(ctx context.Context, limit int) ([]ParkedMessage, error)
+	RequeueParked(ctx context.Context, id string) error
+}
+
+type ParkedMessage struct {
+	Id       string
+	Body     []byte
+	Metadata ports.QueueMessageMetadata
+}
+
+func (m ParkedMessage) Reason() string {
+	return m.Metadata.Headers[HEADER_PARKED_REASON]
+}
+
+// parkingOpts keeps the original message properties and records why, when
+// and from where the message was parked.
+func parkingOpts(msg amqp.Delivery, reason string, cause error, stack []byte) ports.QueuePortPublishOpts {
+	headers := map[string]string{}
+	for key := range msg.Headers {
+		headers[key] = headerString(msg.Headers, key)
+	}
+
+	headers[HEADER_PARKING_ID] = uuid.NewString()
+	headers[HEADER_PARKED_REASON] = reason
+	headers[HEADER_PARKED_AT] = time.Now().UTC().Format(time.RFC3339Nano)
+	headers[HEADER_ORIGINAL_EXCHANGE] = msg.Exchange
+	headers[HEADER_ORIGINAL_ROUTING_KEY] = msg.RoutingKey
+	if cause != nil {
+		headers[HEADER_PARKED_ERROR] = cause.Error()
+	}
+	if stack != nil {
+		headers[HEADER_PARKED_STACK] = string(stack)
+	}
+
+	return ports.QueuePortPublishOpts{
+		Metadata: ports.QueueMessageMetadata{
+			MessageId: msg.MessageId,
+			Type:      msg.Type,
+			Timestamp: msg.Timestamp,
+			Headers:   headers,
+		},
+	}
+}
+
+// unparkedOpts strips the parking headers before a message goes back to
+// the work queue.
+func unparkedOpts(metadata ports.QueueMessageMetadata) ports.QueuePortPublishOpts {
+	headers := map[string]string{}
+	for key, value := range metadata.Headers {
+		if key == HEADER_PARKING_ID || strings.HasPrefix(key, "x-parked-") || strings.HasPrefix(key, "x-original-") {
+			continue
+		}
+		headers[key] = value
+	}
+	metadata.Headers = headers
+
+	return ports.QueuePortPublishOpts{Metadata: metadata}
+}
@@ -0,0 +1,322 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/tracing"
+	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+const QUEUE_JOBS_CHANNEL = "queue_jobs"
+
+var ErrPostgresQueueClosed = errors.New("postgres queue is closed")
+
+// PostgresQueue stores messages in the queue_jobs table. Workers claim due
+// jobs with FOR UPDATE SKIP LOCKED and hold them until locked_until, a job
+// whose lock expired is claimed again by the next worker.
+type PostgresQueue struct {
+	db         *gorm.DB
+	opts       *PostgresQueueOpts
+	deliveries chan amqp.Delivery
+	wake       chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	listenOnce sync.Once
+}
+
+type PostgresQueueOpts struct {
+	QueueName    string
+	ParkingQueue string
+	LockTimeout  time.Duration
+	PollInterval time.Duration
+}
+
+type queueJob struct {
+	Id       int64
+	Body     []byte
+	Metadata []byte
+	Attempts int
+}
+
+func NewPostgresQueue(db *gorm.DB, opts *PostgresQueueOpts) *PostgresQueue {
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = 5 * time.Minute
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.ParkingQueue == "" {
+		opts.ParkingQueue = wb_model.WEBHOOK_PARKING_QUEUE
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &PostgresQueue{
+		db:         db,
+		opts:       opts,
+		deliveries: make(chan amqp.Delivery),
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+func (q *PostgresQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) (err error) {
+	ctx, span := startPublishSpan(ctx, "postgresql", q.opts.QueueName, &opts)
+	defer func() { tracing.End(span, err) }()
+
+	return q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return q.insert(tx, q.opts.QueueName, msg, opts)
+	})
+}
+
+// Park stores the message under the parking queue name, no worker claims
+// jobs from it.
+func (q *PostgresQueue) Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error {
+	return q.insert(q.db.WithContext(ctx), q.opts.ParkingQueue, msg.Body, opts)
+}
+
+func (q *PostgresQueue) ListParked(ctx context.Context, limit int) ([]ParkedMessage, error) {
+	jobs := []queueJob{}
+	db := q.db.WithContext(ctx).Table("queue_jobs").
+		Select("id", "body", "metadata").
+		Where("queue = ?", q.opts.ParkingQueue).
+		Order("id")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	if err := db.Scan(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	parked := make([]ParkedMessage, 0, len(jobs))
+	for _, job := range jobs {
+		metadata := ports.QueueMessageMetadata{}
+		json.Unmarshal(job.Metadata, &metadata)
+		parked = append(parked, ParkedMessage{
+			Id:       metadata.Headers[HEADER_PARKING_ID],
+			Body:     job.Body,
+			Metadata: metadata,
+		})
+	}
+	return parked, nil
+}
+
+func (q *PostgresQueue) RequeueParked(ctx context.Context, id string) error {
+	return q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var job queueJob
+		err := tx.Raw(
+			`DELETE FROM queue_jobs WHERE queue = ? AND metadata->'headers'->>? = ? RETURNING id, body, metadata`,
+			q.opts.ParkingQueue, HEADER_PARKING_ID, id,
+		).Scan(&job).Error
+		if err != nil {
+			return err
+		}
+		if job.Id == 0 {
+			return ErrParkedMessageNotFound
+		}
+
+		metadata := ports.QueueMessageMetadata{}
+		if err := json.Unmarshal(job.Metadata, &metadata); err != nil {
+			return err
+		}
+		return q.insert(tx, q.opts.QueueName, job.Body, unparkedOpts(metadata))
+	})
+}
+
+func (q *PostgresQueue) insert(tx *gorm.DB, queueName string, msg []byte, opts ports.QueuePortPublishOpts) error {
+	metadata, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec(
+		`INSERT INTO queue_jobs (queue, body, metadata, run_at) VALUES (?, ?, CAST(? AS JSONB), NOW() + make_interval(secs => ?))`,
+		queueName, msg, string(metadata), float64(opts.Delay)/1000,
+	).Error
+	if err != nil {
+		return err
+	}
+
+	// delayed jobs are picked up by the poll loop once they are due
+	if opts.Delay > 0 {
+		return nil
+	}
+	return tx.Exec(`SELECT pg_notify(?, ?)`, QUEUE_JOBS_CHANNEL, queueName).Error
+}
+
+func (q *PostgresQueue) Listen() <-chan amqp.Delivery {
+	q.listenOnce.Do(func() {
+		go q.listenNotifications()
+		go q.fetch()
+	})
+	return q.deliveries
+}
+
+func (q *PostgresQueue) Ping(ctx context.Context) error {
+	if q.ctx.Err() != nil {
+		return ErrPostgresQueueClosed
+	}
+	sqlDB, err := q.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (q *PostgresQueue) Close() error {
+	q.cancel()
+	return nil
+}
+
+func (q *PostgresQueue) Ack(tag uint64, multiple bool) error {
+	return q.db.Exec(`DELETE FROM queue_jobs WHERE id = ?`, tag).Error
+}
+
+func (q *PostgresQueue) Nack(tag uint64, multiple bool, requeue bool) error {
+	if !requeue {
+		return q.Ack(tag, multiple)
+	}
+	return q.db.Exec(`UPDATE queue_jobs SET locked_until = NULL, run_at = NOW() WHERE id = ?`, tag).Error
+}
+
+func (q *PostgresQueue) Reject(tag uint64, requeue bool) error {
+	return q.Nack(tag, false, requeue)
+}
+
+func (q *PostgresQueue) fetch() {
+	defer close(q.deliveries)
+
+	for {
+		job, err := q.claim()
+		if err != nil && q.ctx.Err() == nil {
+			log.Error("Error claiming queue job", "err", err)
+		}
+
+		if job != nil {
+			select {
+			case q.deliveries <- q.toDelivery(job):
+			case <-q.ctx.Done():
+				// give the job back so another worker does not wait for the lock
+				q.Nack(uint64(job.Id), false, true)
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(q.opts.PollInterval):
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *PostgresQueue) claim() (*queueJob, error) {
+	var job queueJob
+	err := q.db.WithContext(q.ctx).Raw(`
+		UPDATE queue_jobs
+		SET locked_until = NOW() + make_interval(secs => ?), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM queue_jobs
+			WHERE queue = ? AND run_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, body, metadata, attempts`,
+		q.opts.LockTimeout.Seconds(), q.opts.QueueName,
+	).Scan(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	if job.Id == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (q *PostgresQueue) toDelivery(job *queueJob) amqp.Delivery {
+	metadata := ports.QueueMessageMetadata{}
+	if err := json.Unmarshal(job.Metadata, &metadata); err != nil {
+		log.Error("Error decoding queue job metadata", "err", err, "id", job.Id)
+	}
+
+	return withMetadata(amqp.Delivery{
+		Acknowledger: q,
+		DeliveryTag:  uint64(job.Id),
+		Redelivered:  job.Attempts > 1,
+		ContentType:  "application/json",
+		Body:         job.Body,
+	}, metadata)
+}
+
+// listenNotifications keeps a dedicated connection on LISTEN so idle
+// workers are woken up as soon as a job is published.
+func (q *PostgresQueue) listenNotifications() {
+	for q.ctx.Err() == nil {
+		err := q.waitNotifications()
+		if err != nil && q.ctx.Err() == nil {
+			log.Error("Error listening queue notifications", "err", err)
+		}
+
+		select {
+		case <-time.After(q.opts.PollInterval):
+		case <-q.ctx.Done():
+		}
+	}
+}
+
+func (q *PostgresQueue) waitNotifications() error {
+	sqlDB, err := q.db.DB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(q.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("postgres queue requires the pgx driver")
+		}
+
+		pgConn := c.Conn()
+		if _, err := pgConn.Exec(q.ctx, "LISTEN "+QUEUE_JOBS_CHANNEL); err != nil {
+			return err
+		}
+		defer pgConn.Exec(context.Background(), "UNLISTEN "+QUEUE_JOBS_CHANNEL)
+
+		for {
+			n, err := pgConn.WaitForNotification(q.ctx)
+			if err != nil {
+				return err
+			}
+			if n.Payload == q.opts.QueueName {
+				q.notify()
+			}
+		}
+	})
+}
+
+func (q *PostgresQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
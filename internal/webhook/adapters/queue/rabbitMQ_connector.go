@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -11,6 +12,13 @@ import (
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/metrics"
+	"github.com/webhook-processor/internal/shared/tracing"
+)
+
+var (
+	ErrBrokerConnectionClosed = errors.New("broker connection closed")
+	ErrBrokerChannelClosed    = errors.New("broker channel closed")
 )
 
 type RabbitMQConnector struct {
@@ -24,6 +32,37 @@ type RabbitMQConnOpts struct {
 	QueueName    string
 	ExchangeName string
 	RoutingKey   string
+	ParkingQueue string
+	DelayMode    RabbitMQDelayMode
+	// RetryDelays are the TTL buckets declared when DelayMode is ttl
+	RetryDelays []time.Duration
+}
+
+type RabbitMQDelayMode string
+
+const (
+	// DelayModePlugin relies on the rabbitmq_delayed_message_exchange plugin
+	DelayModePlugin RabbitMQDelayMode = "plugin"
+	// DelayModeTTL parks delayed messages on a ladder of retry queues that
+	// dead-letter back to the work exchange once their TTL expires
+	DelayModeTTL RabbitMQDelayMode = "ttl"
+)
+
+// Validate rejects unknown modes, empty is the plugin mode.
+func (m RabbitMQDelayMode) Validate() error {
+	switch m {
+	case "", DelayModePlugin, DelayModeTTL:
+		return nil
+	}
+	return fmt.Errorf("unknown RabbitMQ delay mode %q, expected %s or %s", m, DelayModePlugin, DelayModeTTL)
+}
+
+var DEFAULT_RETRY_DELAYS = []time.Duration{
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
 }
 
 func NewRabbitMQConnector(opts *RabbitMQConnOpts) *RabbitMQConnector {
@@ -42,16 +81,22 @@ func NewRabbitMQConnector(opts *RabbitMQConnOpts) *RabbitMQConnector {
 	})
 	failOnError(err, "Failed to connect to RabbitMQ")
 
+	metrics.SetBrokerConnected(true)
+	go watchConnection(conn)
+
 	ch, err := conn.Channel()
 	failOnError(err, "Failed to open a channel")
 
-	err = ch.ExchangeDeclare(opts.ExchangeName, "x-delayed-message", true, false, false, false, amqp.Table{
-		"x-delayed-type": "fanout",
-	})
+	if opts.DelayMode == DelayModeTTL {
+		// an exchange can't change its type, switching modes needs a new exchange name
+		err = ch.ExchangeDeclare(opts.ExchangeName, "direct", true, false, false, false, nil)
+	} else {
+		err = ch.ExchangeDeclare(opts.ExchangeName, "x-delayed-message", true, false, false, false, amqp.Table{
+			"x-delayed-type": "fanout",
+		})
+	}
 	failOnError(err, "failed to declare exchange")
 
-	err = ch.QueueBind(opts.QueueName, opts.RoutingKey, opts.ExchangeName, false, nil)
-
 	q, err := ch.QueueDeclare(
 		opts.QueueName,
 		true,  // durable
@@ -62,6 +107,22 @@ func NewRabbitMQConnector(opts *RabbitMQConnOpts) *RabbitMQConnector {
 	)
 	failOnError(err, "Failed to declare a queue")
 
+	err = ch.QueueBind(opts.QueueName, opts.RoutingKey, opts.ExchangeName, false, nil)
+	failOnError(err, "Failed to bind the queue")
+
+	if opts.ParkingQueue == "" {
+		opts.ParkingQueue = wb_model.WEBHOOK_PARKING_QUEUE
+	}
+	_, err = ch.QueueDeclare(opts.ParkingQueue, true, false, false, false, nil)
+	failOnError(err, "Failed to declare the parking queue")
+
+	if opts.DelayMode == DelayModeTTL {
+		if len(opts.RetryDelays) == 0 {
+			opts.RetryDelays = DEFAULT_RETRY_DELAYS
+		}
+		declareRetryQueues(ch, opts)
+	}
+
 	return &RabbitMQConnector{
 		opts: opts,
 		conn: conn,
@@ -87,38 +148,198 @@ func (l *RabbitMQConnector) Listen() <-chan amqp.Delivery {
 	return msgs
 }
 
-func (l *RabbitMQConnector) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) error {
-	err := l.ch.PublishWithContext(ctx,
-		l.opts.ExchangeName,
-		l.opts.RoutingKey,
+func (l *RabbitMQConnector) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) (err error) {
+	ctx, span := startPublishSpan(ctx, "rabbitmq", l.opts.ExchangeName, &opts)
+	defer func() { tracing.End(span, err) }()
+
+	exchange, routingKey := l.opts.ExchangeName, l.opts.RoutingKey
+	if l.opts.DelayMode == DelayModeTTL && opts.Delay > 0 {
+		// published on the default exchange, straight into the retry queue
+		bucket := nearestRetryDelay(l.opts.RetryDelays, time.Duration(opts.Delay)*time.Millisecond)
+		exchange, routingKey = "", retryQueueName(l.opts.QueueName, bucket)
+	}
+
+	headers := amqpHeaders(opts.Metadata)
+	headers["x-delay"] = opts.Delay
+
+	err = l.ch.PublishWithContext(ctx,
+		exchange,
+		routingKey,
 		false,
 		false,
 		amqp.Publishing{
 			ContentType: "application/json",
 			Body:        msg,
-			Headers: amqp.Table{
-				"x-delay": opts.Delay,
-			},
+			MessageId:   opts.Metadata.MessageId,
+			Type:        opts.Metadata.Type,
+			Timestamp:   opts.Metadata.Timestamp,
+			Headers:     headers,
 		})
-	failOnError(err, "Failed to publish a message")
-
+	// the caller decides, a consumer hands the message back to the broker
+	if err != nil {
+		metrics.QueuePublishErrors.WithLabelValues("publish").Inc()
+	}
 	return err
 }
 
+func (l *RabbitMQConnector) Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error {
+	return l.ch.PublishWithContext(ctx,
+		"",
+		l.opts.ParkingQueue,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Body:         msg.Body,
+			MessageId:    opts.Metadata.MessageId,
+			Type:         opts.Metadata.Type,
+			Timestamp:    opts.Metadata.Timestamp,
+			Headers:      amqpHeaders(opts.Metadata),
+		})
+}
+
+// ListParked peeks at the parking queue, messages are fetched without ack
+// and handed back to the queue once listed.
+func (l *RabbitMQConnector) ListParked(ctx context.Context, limit int) ([]ParkedMessage, error) {
+	parked := []ParkedMessage{}
+	var last uint64
+	defer func() {
+		if last > 0 {
+			l.ch.Nack(last, true, true)
+		}
+	}()
+
+	for limit <= 0 || len(parked) < limit {
+		d, ok, err := l.ch.Get(l.opts.ParkingQueue, false)
+		if err != nil {
+			return parked, err
+		}
+		if !ok {
+			break
+		}
+		last = d.DeliveryTag
+		parked = append(parked, parkedFromDelivery(d))
+	}
+
+	return parked, nil
+}
+
+func (l *RabbitMQConnector) RequeueParked(ctx context.Context, id string) error {
+	var last uint64
+	defer func() {
+		if last > 0 {
+			l.ch.Nack(last, true, true)
+		}
+	}()
+
+	for {
+		d, ok, err := l.ch.Get(l.opts.ParkingQueue, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrParkedMessageNotFound
+		}
+
+		msg := parkedFromDelivery(d)
+		if msg.Id != id {
+			last = d.DeliveryTag
+			continue
+		}
+
+		if err := l.Publish(ctx, msg.Body, unparkedOpts(msg.Metadata)); err != nil {
+			d.Nack(false, true)
+			return err
+		}
+		return d.Ack(false)
+	}
+}
+
+func parkedFromDelivery(d amqp.Delivery) ParkedMessage {
+	headers := map[string]string{}
+	for key := range d.Headers {
+		headers[key] = headerString(d.Headers, key)
+	}
+
+	return ParkedMessage{
+		Id:   headers[HEADER_PARKING_ID],
+		Body: d.Body,
+		Metadata: ports.QueueMessageMetadata{
+			MessageId: d.MessageId,
+			Type:      d.Type,
+			Timestamp: d.Timestamp,
+			Headers:   headers,
+		},
+	}
+}
+
+// Ping fails once the connection or the channel closed, neither is
+// reopened.
+func (l *RabbitMQConnector) Ping(ctx context.Context) error {
+	if l.conn.IsClosed() {
+		return ErrBrokerConnectionClosed
+	}
+	if l.ch.IsClosed() {
+		return ErrBrokerChannelClosed
+	}
+	return nil
+}
+
 func (l *RabbitMQConnector) Close() error {
 	err := l.ch.Close()
 	err = l.conn.Close()
 	return err
 }
 
+func declareRetryQueues(ch *amqp.Channel, opts *RabbitMQConnOpts) {
+	for _, delay := range opts.RetryDelays {
+		_, err := ch.QueueDeclare(
+			retryQueueName(opts.QueueName, delay),
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    opts.ExchangeName,
+				"x-dead-letter-routing-key": opts.RoutingKey,
+			},
+		)
+		failOnError(err, "Failed to declare a retry queue")
+	}
+}
+
+func retryQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queueName, delay)
+}
+
+func nearestRetryDelay(buckets []time.Duration, delay time.Duration) time.Duration {
+	nearest := buckets[0]
+	for _, bucket := range buckets[1:] {
+		if (bucket - delay).Abs() < (nearest - delay).Abs() {
+			nearest = bucket
+		}
+	}
+	return nearest
+}
+
+// watchConnection flags the broker as disconnected as soon as the
+// connection closes, there is no reconnection.
+func watchConnection(conn *amqp.Connection) {
+	<-conn.NotifyClose(make(chan *amqp.Error, 1))
+	metrics.SetBrokerConnected(false)
+}
+
 func startHealthCheck(ch *amqp.Channel) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		<-ticker.C
+		metrics.SetBrokerConnected(!ch.IsClosed())
 		if ch.IsClosed() {
-			log.Error("Health check failed: broker not connected", nil)
+			log.Error("Health check failed: broker not connected")
 		} else {
 			log.Debug("Health check passed: broker connected")
 		}
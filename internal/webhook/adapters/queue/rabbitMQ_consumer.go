@@ -2,13 +2,21 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
-	"math"
+	"errors"
+	"fmt"
 	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/metrics"
+	"github.com/webhook-processor/internal/shared/tracing"
 	"github.com/webhook-processor/internal/webhook/ports"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	wb_model "github.com/webhook-processor/internal/webhook/domain/model"
 )
@@ -16,57 +24,245 @@ import (
 type RabbitMQConsumer struct {
 	service ports.WebhookServicePort
 	queue   ports.QueuePort
+	// parker is optional, without it poison messages are dropped
+	parker Parker
+	// infraFailures counts consecutive transient infrastructure errors, the
+	// consumer is unhealthy while it is above zero
+	infraFailures atomic.Int64
+	// workers counts the workers still reading deliveries
+	workers atomic.Int64
+	// lastDelivery is the unix nanos of the last successful delivery
+	lastDelivery atomic.Int64
 }
 
-const MAX_DELAY = 60000
+var ErrConsumerNotSubscribed = errors.New("consumer is not reading deliveries")
+var ErrConsumerInfraFailures = errors.New("consumer deliveries failing on infrastructure errors")
 
 func NewRabbitMQConsumer(service ports.WebhookServicePort, queue ports.QueuePort) *RabbitMQConsumer {
-	return &RabbitMQConsumer{service: service, queue: queue}
+	parker, _ := queue.(Parker)
+	return &RabbitMQConsumer{service: service, queue: queue, parker: parker}
 }
 
-func (c *RabbitMQConsumer) Consume(msg amqp091.Delivery) error {
-	log.Info("Received a message", "msg", msg.Body)
-	wbEvent := wb_model.WebhookEventMessage{}
-	err := json.Unmarshal(msg.Body, &wbEvent)
+// Run consumes msgs with the given number of workers until the channel is
+// closed or ctx is done, it works with any source of deliveries (RabbitMQ
+// or the in-memory queue). It returns once every worker has stopped.
+func (c *RabbitMQConsumer) Run(ctx context.Context, msgs <-chan amqp091.Delivery, workers int) {
+	wg := sync.WaitGroup{}
+	for range max(workers, 1) {
+		wg.Add(1)
+		c.workers.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.workers.Add(-1)
+			c.work(ctx, msgs)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *RabbitMQConsumer) work(ctx context.Context, msgs <-chan amqp091.Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d, ok := <-msgs:
+			if !ok {
+				return
+			}
+			metrics.WorkersInFlight.Inc()
+			if err := c.Consume(ctx, d); err != nil {
+				log.ErrorContext(ctx, "Error consuming message", "err", err)
+			}
+			metrics.WorkersInFlight.Dec()
+		}
+	}
+}
+
+func (c *RabbitMQConsumer) Consume(ctx context.Context, msg amqp091.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.ErrorContext(ctx, "Recovered from panic while consuming message", "panic", r)
+			err = c.park(ctx, msg, PARK_REASON_PANIC, fmt.Errorf("%v", r), debug.Stack())
+		}
+	}()
+
+	log.InfoContext(ctx, "Received a message", "msg", msg.Body)
+	wbEvent, err := decodeMessage(msg)
 	if err != nil {
-		return ack(msg)
+		log.ErrorContext(ctx, "Malformed message", "err", err)
+		return c.park(ctx, msg, PARK_REASON_MALFORMED, err, nil)
 	}
 
-	ctx := context.Background()
+	// the span continues the trace of the ingestion that published the event
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, wbEvent.TraceParent, wbEvent.TraceState), "process webhook_queue",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.message.id", wbEvent.Id),
+			attribute.Int("webhook.event.attempt", wbEvent.Attempt),
+			attribute.Int("webhook.id", wbEvent.WebhookId),
+			attribute.String("webhook.tenant_id", wbEvent.TenantId),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+	ctx = log.WithContext(ctx, "event_id", wbEvent.Id, "webhook_id", wbEvent.WebhookId, "attempt", wbEvent.Attempt)
+
 	wb_event, wb_error := c.service.SendWebhook(ctx, wbEvent)
+	if wb_error != nil {
+		span.SetAttributes(attribute.String("webhook.error.code", wb_error.Code))
+	}
+
+	// delivery was interrupted, hand the message back to the broker
+	if ctx.Err() != nil {
+		return nack(ctx, msg)
+	}
+
+	if wb_error != nil && wb_error.IsTransient() {
+		return c.retryLater(ctx, msg, wbEvent, wb_error)
+	}
+	c.markHealthy()
+	if wb_error == nil {
+		c.lastDelivery.Store(time.Now().UnixNano())
+	}
 
 	if wb_error != nil && wb_error.IsRetryable() {
-		log.Info(wb_error.Error())
+		log.InfoContext(ctx, "delivery failed, retrying", "err", wb_error)
 		delay := getDelay(wb_event.Tries)
-		log.Info("publishing message with delay", "delay", delay)
-		err := c.queue.Publish(ctx, msg.Body, ports.QueuePortPublishOpts{Delay: delay})
+		if wb_error.RetryAfter > 0 {
+			delay = withJitter(wb_error.RetryAfter)
+		}
+		log.InfoContext(ctx, "publishing message with delay", "delay", delay)
+		metrics.RetryDelay.Observe(float64(delay) / 1000)
+		next := wbEvent.NextAttempt()
+		// a throttled delivery was not attempted
+		if errors.Is(wb_error, wb_model.ErrRateLimited) {
+			next = wbEvent
+		}
+		body, err := next.Encode()
+		if err != nil {
+			return err
+		}
+		err = c.queue.Publish(ctx, body, ports.WebhookEventPublishOpts(next, delay))
 		if err != nil {
-			log.Error("Error publishing message", err)
+			metrics.QueuePublishErrors.WithLabelValues("retry").Inc()
+			log.ErrorContext(ctx, "Error publishing message", "err", err)
+			// the broker redelivers the message, the retry is not lost
+			nack(ctx, msg)
 			return err
 		}
 	}
 
-	return ack(msg)
+	return ack(ctx, msg)
+}
+
+// Healthy fails while the last deliveries could not reach the database.
+func (c *RabbitMQConsumer) Healthy(ctx context.Context) error {
+	if failures := c.infraFailures.Load(); failures > 0 {
+		return fmt.Errorf("%w: %d in a row", ErrConsumerInfraFailures, failures)
+	}
+	return nil
+}
+
+// Subscribed fails once every worker stopped, the delivery channel closed
+// or Run was never called.
+func (c *RabbitMQConsumer) Subscribed(ctx context.Context) error {
+	if c.workers.Load() == 0 {
+		return ErrConsumerNotSubscribed
+	}
+	return nil
+}
+
+// LastDelivery is the time of the last successful delivery, zero until
+// then.
+func (c *RabbitMQConsumer) LastDelivery() time.Time {
+	nanos := c.lastDelivery.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// retryLater keeps the message when the event could not be processed at
+// all: the same attempt is published again with a backoff, and when even
+// that fails the message goes back to the broker.
+func (c *RabbitMQConsumer) retryLater(ctx context.Context, msg amqp091.Delivery, wbEvent wb_model.WebhookEventMessage, wb_error *wb_model.WebhookError) error {
+	failures := c.infraFailures.Add(1)
+	if failures == 1 {
+		log.ErrorContext(ctx, "Consumer unhealthy, infrastructure unavailable", "err", wb_error)
+	}
+
+	delay := getDelay(int(failures - 1))
+	log.InfoContext(ctx, "infrastructure failure, retrying message later", "delay", delay, "failures", failures)
+	metrics.RetryDelay.Observe(float64(delay) / 1000)
+
+	body, err := wbEvent.Encode()
+	if err == nil {
+		err = c.queue.Publish(ctx, body, ports.WebhookEventPublishOpts(wbEvent, delay))
+	}
+	if err != nil {
+		metrics.QueuePublishErrors.WithLabelValues("retry").Inc()
+		log.ErrorContext(ctx, "Error publishing message", "err", err)
+		// back off before requeueing, the broker redelivers right away
+		select {
+		case <-time.After(time.Duration(delay) * time.Millisecond):
+		case <-ctx.Done():
+		}
+		return nack(ctx, msg)
+	}
+
+	return ack(ctx, msg)
+}
+
+func (c *RabbitMQConsumer) markHealthy() {
+	if c.infraFailures.Swap(0) > 0 {
+		log.Info("Consumer healthy again, infrastructure recovered")
+	}
+}
+
+// park moves the message to the parking queue and acks it, when parking
+// fails the message is requeued rather than lost.
+func (c *RabbitMQConsumer) park(ctx context.Context, msg amqp091.Delivery, reason string, cause error, stack []byte) error {
+	if c.parker == nil {
+		log.ErrorContext(ctx, "No parking queue, dropping message", "reason", reason)
+		return ack(ctx, msg)
+	}
+
+	if err := c.parker.Park(context.WithoutCancel(ctx), msg, parkingOpts(msg, reason, cause, stack)); err != nil {
+		metrics.QueuePublishErrors.WithLabelValues("park").Inc()
+		log.ErrorContext(ctx, "Error parking message", "err", err, "reason", reason)
+		nack(ctx, msg)
+		return err
+	}
+
+	log.InfoContext(ctx, "Message parked", "reason", reason)
+	return ack(ctx, msg)
 }
 
-func ack(msg amqp091.Delivery) error {
-	log.Debug("acknowledging message")
-	msg.Headers["x-delay"] = 4000
+func ack(ctx context.Context, msg amqp091.Delivery) error {
+	log.DebugContext(ctx, "acknowledging message")
 	err := msg.Ack(false)
 	if err != nil {
-		log.Error("Error acknowledging message", err)
+		log.ErrorContext(ctx, "Error acknowledging message", "err", err)
+	}
+	return err
+}
+
+func nack(ctx context.Context, msg amqp091.Delivery) error {
+	log.DebugContext(ctx, "requeueing message")
+	err := msg.Nack(false, true)
+	if err != nil {
+		log.ErrorContext(ctx, "Error requeueing message", "err", err)
 	}
 	return err
 }
 
 func getDelay(retryCount int) int {
-	// 2 ^ 0 = 1
-	// 2 ^ 1 = 2
-	// 2 ^ 2 = 4
-	// 2 ^ 3 = 8
-	// 2 ^ 4 = 16
-	exp := math.Pow(2, float64(retryCount))
-	delay := math.Min(exp*1000, MAX_DELAY)
+	return withJitter(wb_model.RetryBackoff(retryCount))
+}
+
+// withJitter returns backoff in milliseconds plus up to 50% of jitter.
+func withJitter(backoff time.Duration) int {
+	delay := float64(backoff.Milliseconds())
 	jitter := rand.Float64() * (delay * 0.5)
 
 	return int(delay) + int(jitter)
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/webhook-processor/internal/shared/tracing"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	bodyTraceParent   = "00-11111111111111111111111111111111-1111111111111111-01"
+	headerTraceParent = "00-22222222222222222222222222222222-2222222222222222-01"
+	headerTraceId     = "22222222222222222222222222222222"
+)
+
+type deliveredService struct{}
+
+func (deliveredService) SendWebhook(ctx context.Context, msg model.WebhookEventMessage) (*model.WebhookEvent, *model.WebhookError) {
+	return &model.WebhookEvent{Id: msg.Id, Status: model.WebhookEventsStatusDelivered}, nil
+}
+
+// TestConsumeSpanParent checks the consumer span continues the trace of the
+// publish carried by the headers rather than the one copied in the body.
+func TestConsumeSpanParent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	q := NewMemoryQueue(&MemoryQueueOpts{})
+	defer q.Close()
+
+	// the publish span joins the trace of the header and injects it
+	ctx := tracing.Extract(context.Background(), headerTraceParent, "")
+	body := []byte(`{"id":"event-1","traceparent":"` + bodyTraceParent + `"}`)
+	if err := q.Publish(ctx, body, ports.QueuePortPublishOpts{Metadata: ports.QueueMessageMetadata{MessageId: "event-1"}}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if err := NewRabbitMQConsumer(deliveredService{}, q).Consume(context.Background(), receive(t, q.Listen())); err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+
+	var publish, process sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "process webhook_queue":
+			process = span
+		default:
+			publish = span
+		}
+	}
+	if publish == nil || process == nil {
+		t.Fatalf("recorded spans %v", recorder.Ended())
+	}
+	if got := process.Parent().TraceID().String(); got != headerTraceId {
+		t.Errorf("process span trace id = %s, want the header trace %s", got, headerTraceId)
+	}
+	if !process.Parent().IsRemote() || process.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Errorf("process span parent = %s, want the publish span %s", process.Parent().SpanID(), publish.SpanContext().SpanID())
+	}
+}
+
+func TestDecodeMessageTraceParentFallback(t *testing.T) {
+	body := []byte(`{"id":"event-1","traceparent":"` + bodyTraceParent + `","tracestate":"vendor=body"}`)
+
+	msg, err := decodeMessage(amqp.Delivery{Body: body})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.TraceParent != bodyTraceParent || msg.TraceState != "vendor=body" {
+		t.Errorf("without headers trace context = %q %q, want the body one", msg.TraceParent, msg.TraceState)
+	}
+
+	msg, err = decodeMessage(amqp.Delivery{Body: body, Headers: amqp.Table{ports.HEADER_TRACEPARENT: headerTraceParent}})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.TraceParent != headerTraceParent || msg.TraceState != "" {
+		t.Errorf("with headers trace context = %q %q, want the header one", msg.TraceParent, msg.TraceState)
+	}
+}
+
+// failingPublishQueue is a memory queue whose broker refuses publishes,
+// deliveries and parking still work.
+type failingPublishQueue struct {
+	*MemoryQueue
+}
+
+func (q failingPublishQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) error {
+	return errors.New("channel closed")
+}
+
+type failingService struct {
+	err *model.WebhookError
+}
+
+func (s failingService) SendWebhook(ctx context.Context, msg model.WebhookEventMessage) (*model.WebhookEvent, *model.WebhookError) {
+	return &model.WebhookEvent{Id: msg.Id, Tries: 1}, s.err
+}
+
+// TestConsumeRequeuesOnPublishFailure checks a retry that can't be
+// published hands the message back to the broker instead of parking it.
+func TestConsumeRequeuesOnPublishFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     *model.WebhookError
+		wantErr bool
+	}{
+		{name: "retryable", err: model.New(errors.New("receiver returned 503"), true), wantErr: true},
+		// retryLater backs off then requeues, the message is handled
+		{name: "transient", err: model.NewTransient(errors.New("database unavailable"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewMemoryQueue(&MemoryQueueOpts{})
+			defer q.Close()
+			ctx := context.Background()
+			if err := q.Publish(ctx, []byte(`{"id":"event-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+
+			consumer := NewRabbitMQConsumer(failingService{err: tt.err}, failingPublishQueue{q})
+			if err := consumer.Consume(ctx, receive(t, q.Listen())); (err != nil) != tt.wantErr {
+				t.Errorf("consume = %v, want error %t", err, tt.wantErr)
+			}
+
+			if parked, _ := q.ListParked(ctx, 0); len(parked) != 0 {
+				t.Fatalf("message parked: %+v", parked)
+			}
+			if d := receive(t, q.Listen()); !d.Redelivered {
+				t.Errorf("message not requeued")
+			}
+		})
+	}
+}
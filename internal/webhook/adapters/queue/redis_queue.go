@@ -0,0 +1,427 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/tracing"
+	ports "github.com/webhook-processor/internal/webhook/ports"
+)
+
+var ErrRedisMessageNotInFlight = errors.New("redis message is not in flight")
+
+// promoteDelayed moves a due member of the delayed sorted set onto the
+// stream, ZREM guarantees only one mover promotes it.
+var promoteDelayed = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+	redis.call('XADD', KEYS[2], '*', 'body', ARGV[2], 'metadata', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// RedisQueue is a QueuePort on top of a Redis stream read through a
+// consumer group, delayed messages wait in a sorted set scored by due time.
+type RedisQueue struct {
+	rdb        *redis.Client
+	opts       *RedisQueueOpts
+	deliveries chan amqp.Delivery
+	ctx        context.Context
+	cancel     context.CancelFunc
+	listenOnce sync.Once
+
+	mu       sync.Mutex
+	inFlight map[uint64]redisInFlight
+	nextTag  uint64
+}
+
+// redisInFlight is a delivered message, its heartbeat runs until it is
+// acked or nacked.
+type redisInFlight struct {
+	msg  redis.XMessage
+	done chan struct{}
+}
+
+type RedisQueueOpts struct {
+	Addr     string
+	Stream   string
+	Group    string
+	Consumer string
+	// DelayedKey is the sorted set holding delayed messages
+	DelayedKey    string
+	ParkingStream string
+	// ClaimMinIdle is how long a message stays pending before another
+	// consumer reclaims it with XAUTOCLAIM, deliveries in progress are
+	// claimed again every half ClaimMinIdle so they never look idle.
+	ClaimMinIdle  time.Duration
+	MoverInterval time.Duration
+}
+
+func NewRedisQueue(opts *RedisQueueOpts) *RedisQueue {
+	if opts.DelayedKey == "" {
+		opts.DelayedKey = opts.Stream + ":delayed"
+	}
+	if opts.ParkingStream == "" {
+		opts.ParkingStream = opts.Stream + ":parking"
+	}
+	if opts.ClaimMinIdle <= 0 {
+		opts.ClaimMinIdle = time.Minute
+	}
+	if opts.MoverInterval <= 0 {
+		opts.MoverInterval = 500 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rdb := redis.NewClient(&redis.Options{Addr: opts.Addr, DialTimeout: 2 * time.Second})
+
+	err := rdb.Ping(ctx).Err()
+	failOnError(err, "Failed to connect to Redis")
+
+	err = rdb.XGroupCreateMkStream(ctx, opts.Stream, opts.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		failOnError(err, "Failed to create the consumer group")
+	}
+
+	return &RedisQueue{
+		rdb:        rdb,
+		opts:       opts,
+		deliveries: make(chan amqp.Delivery),
+		ctx:        ctx,
+		cancel:     cancel,
+		inFlight:   map[uint64]redisInFlight{},
+	}
+}
+
+// redisDelayedMessage is the member stored in the delayed sorted set
+type redisDelayedMessage struct {
+	// Id keeps members unique, the same body can be delayed twice
+	Id       string `json:"id"`
+	Body     string `json:"body"`
+	Metadata string `json:"metadata"`
+}
+
+func (q *RedisQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) (err error) {
+	ctx, span := startPublishSpan(ctx, "redis", q.opts.Stream, &opts)
+	defer func() { tracing.End(span, err) }()
+
+	metadata, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		return err
+	}
+
+	if opts.Delay <= 0 {
+		return q.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: q.opts.Stream,
+			Values: map[string]interface{}{"body": msg, "metadata": metadata},
+		}).Err()
+	}
+
+	member, err := json.Marshal(redisDelayedMessage{
+		Id:       uuid.NewString(),
+		Body:     string(msg),
+		Metadata: string(metadata),
+	})
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().Add(time.Duration(opts.Delay) * time.Millisecond)
+	return q.rdb.ZAdd(ctx, q.opts.DelayedKey, redis.Z{
+		Score:  float64(due.UnixMilli()),
+		Member: member,
+	}).Err()
+}
+
+func (q *RedisQueue) Park(ctx context.Context, msg amqp.Delivery, opts ports.QueuePortPublishOpts) error {
+	metadata, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return q.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.opts.ParkingStream,
+		Values: map[string]interface{}{"body": msg.Body, "metadata": metadata},
+	}).Err()
+}
+
+func (q *RedisQueue) ListParked(ctx context.Context, limit int) ([]ParkedMessage, error) {
+	var msgs []redis.XMessage
+	var err error
+	if limit > 0 {
+		msgs, err = q.rdb.XRangeN(ctx, q.opts.ParkingStream, "-", "+", int64(limit)).Result()
+	} else {
+		msgs, err = q.rdb.XRange(ctx, q.opts.ParkingStream, "-", "+").Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parked := make([]ParkedMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		parked = append(parked, redisParkedMessage(msg))
+	}
+	return parked, nil
+}
+
+func (q *RedisQueue) RequeueParked(ctx context.Context, id string) error {
+	parked, err := q.rdb.XRange(ctx, q.opts.ParkingStream, "-", "+").Result()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range parked {
+		p := redisParkedMessage(msg)
+		if p.Id != id {
+			continue
+		}
+
+		if err := q.Publish(ctx, p.Body, unparkedOpts(p.Metadata)); err != nil {
+			return err
+		}
+		return q.rdb.XDel(ctx, q.opts.ParkingStream, msg.ID).Err()
+	}
+
+	return ErrParkedMessageNotFound
+}
+
+func redisParkedMessage(msg redis.XMessage) ParkedMessage {
+	body, _ := msg.Values["body"].(string)
+	rawMetadata, _ := msg.Values["metadata"].(string)
+
+	metadata := ports.QueueMessageMetadata{}
+	json.Unmarshal([]byte(rawMetadata), &metadata)
+
+	return ParkedMessage{
+		Id:       metadata.Headers[HEADER_PARKING_ID],
+		Body:     []byte(body),
+		Metadata: metadata,
+	}
+}
+
+func (q *RedisQueue) Listen() <-chan amqp.Delivery {
+	q.listenOnce.Do(func() {
+		go q.moveDelayed()
+		go q.fetch()
+	})
+	return q.deliveries
+}
+
+func (q *RedisQueue) Ping(ctx context.Context) error {
+	return q.rdb.Ping(ctx).Err()
+}
+
+func (q *RedisQueue) Close() error {
+	q.cancel()
+	return q.rdb.Close()
+}
+
+func (q *RedisQueue) Ack(tag uint64, multiple bool) error {
+	msg, err := q.take(tag)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.XAck(context.Background(), q.opts.Stream, q.opts.Group, msg.ID)
+		pipe.XDel(context.Background(), q.opts.Stream, msg.ID)
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) Nack(tag uint64, multiple bool, requeue bool) error {
+	msg, err := q.take(tag)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		if requeue {
+			pipe.XAdd(context.Background(), &redis.XAddArgs{Stream: q.opts.Stream, Values: msg.Values})
+		}
+		pipe.XAck(context.Background(), q.opts.Stream, q.opts.Group, msg.ID)
+		pipe.XDel(context.Background(), q.opts.Stream, msg.ID)
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) Reject(tag uint64, requeue bool) error {
+	return q.Nack(tag, false, requeue)
+}
+
+func (q *RedisQueue) fetch() {
+	defer close(q.deliveries)
+
+	for q.ctx.Err() == nil {
+		msgs, redelivered, err := q.read()
+		if err != nil && !errors.Is(err, redis.Nil) && q.ctx.Err() == nil {
+			log.Error("Error reading stream", "err", err)
+			select {
+			case <-time.After(time.Second):
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			select {
+			case q.deliveries <- q.toDelivery(msg, redelivered):
+			case <-q.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// read first reclaims messages abandoned by dead consumers and then waits
+// for new ones.
+func (q *RedisQueue) read() ([]redis.XMessage, bool, error) {
+	claimed, _, err := q.rdb.XAutoClaim(q.ctx, &redis.XAutoClaimArgs{
+		Stream:   q.opts.Stream,
+		Group:    q.opts.Group,
+		Consumer: q.opts.Consumer,
+		MinIdle:  q.opts.ClaimMinIdle,
+		Start:    "0-0",
+		Count:    10,
+	}).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(claimed) > 0 {
+		return claimed, true, nil
+	}
+
+	streams, err := q.rdb.XReadGroup(q.ctx, &redis.XReadGroupArgs{
+		Group:    q.opts.Group,
+		Consumer: q.opts.Consumer,
+		Streams:  []string{q.opts.Stream, ">"},
+		Count:    1,
+		Block:    5 * time.Second,
+	}).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	msgs := []redis.XMessage{}
+	for _, stream := range streams {
+		msgs = append(msgs, stream.Messages...)
+	}
+	return msgs, false, nil
+}
+
+func (q *RedisQueue) moveDelayed() {
+	ticker := time.NewTicker(q.opts.MoverInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.ctx.Done():
+			return
+		}
+
+		members, err := q.rdb.ZRangeByScore(q.ctx, q.opts.DelayedKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			if q.ctx.Err() == nil {
+				log.Error("Error reading delayed messages", "err", err)
+			}
+			continue
+		}
+
+		for _, member := range members {
+			delayed := redisDelayedMessage{}
+			if err := json.Unmarshal([]byte(member), &delayed); err != nil {
+				log.Error("Error decoding delayed message", "err", err)
+				continue
+			}
+
+			err := promoteDelayed.Run(q.ctx, q.rdb, []string{q.opts.DelayedKey, q.opts.Stream}, member, delayed.Body, delayed.Metadata).Err()
+			if err != nil {
+				log.Error("Error promoting delayed message", "err", err)
+			}
+		}
+	}
+}
+
+func (q *RedisQueue) toDelivery(msg redis.XMessage, redelivered bool) amqp.Delivery {
+	q.mu.Lock()
+	q.nextTag++
+	tag := q.nextTag
+	done := make(chan struct{})
+	q.inFlight[tag] = redisInFlight{msg: msg, done: done}
+	q.mu.Unlock()
+
+	go q.heartbeat(msg.ID, done)
+
+	body, _ := msg.Values["body"].(string)
+	rawMetadata, _ := msg.Values["metadata"].(string)
+
+	metadata := ports.QueueMessageMetadata{}
+	if rawMetadata != "" {
+		if err := json.Unmarshal([]byte(rawMetadata), &metadata); err != nil {
+			log.Error("Error decoding message metadata", "err", err, "id", msg.ID)
+		}
+	}
+
+	return withMetadata(amqp.Delivery{
+		Acknowledger: q,
+		DeliveryTag:  tag,
+		Redelivered:  redelivered,
+		ContentType:  "application/json",
+		Body:         []byte(body),
+	}, metadata)
+}
+
+// heartbeat keeps a long delivery from being reclaimed by another consumer,
+// XCLAIM JUSTID resets the idle time of the pending id until done is closed.
+func (q *RedisQueue) heartbeat(id string, done chan struct{}) {
+	ticker := time.NewTicker(q.opts.ClaimMinIdle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			err := q.rdb.XClaimJustID(q.ctx, &redis.XClaimArgs{
+				Stream:   q.opts.Stream,
+				Group:    q.opts.Group,
+				Consumer: q.opts.Consumer,
+				Messages: []string{id},
+			}).Err()
+			if err != nil && q.ctx.Err() == nil {
+				log.Error("Error extending message idle time", "err", err, "id", id)
+			}
+		}
+	}
+}
+
+func (q *RedisQueue) take(tag uint64) (redis.XMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	inFlight, ok := q.inFlight[tag]
+	if !ok {
+		return redis.XMessage{}, ErrRedisMessageNotInFlight
+	}
+	delete(q.inFlight, tag)
+	close(inFlight.done)
+	return inFlight.msg, nil
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+func newTestRedisQueue(t *testing.T, addr string, consumer string) *RedisQueue {
+	q := NewRedisQueue(&RedisQueueOpts{
+		Addr:          addr,
+		Stream:        "webhooks",
+		Group:         "webhooks",
+		Consumer:      consumer,
+		ClaimMinIdle:  100 * time.Millisecond,
+		MoverInterval: 20 * time.Millisecond,
+	})
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func TestRedisQueuePublishAck(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q := newTestRedisQueue(t, mr.Addr(), "c-1")
+	ctx := context.Background()
+
+	metadata := ports.QueueMessageMetadata{MessageId: "m-1", Type: "webhook_event", Headers: map[string]string{"x-tenant": "acme"}}
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{Metadata: metadata}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	msg := receive(t, q.Listen())
+	if string(msg.Body) != `{"id":"e-1"}` || msg.MessageId != "m-1" || msg.Type != "webhook_event" || msg.Redelivered {
+		t.Fatalf("unexpected delivery %+v", msg)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := msg.Ack(false); err != ErrRedisMessageNotInFlight {
+		t.Fatalf("second ack = %v, want %v", err, ErrRedisMessageNotInFlight)
+	}
+
+	if n, err := q.rdb.XLen(ctx, "webhooks").Result(); err != nil || n != 0 {
+		t.Fatalf("stream length = %d (%v), acked messages must be deleted", n, err)
+	}
+	pending, err := q.rdb.XPending(ctx, "webhooks", "webhooks").Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatalf("pending = %+v (%v), want none", pending, err)
+	}
+}
+
+func TestRedisQueueNackRequeue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q := newTestRedisQueue(t, mr.Addr(), "c-1")
+	ctx := context.Background()
+
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	deliveries := q.Listen()
+
+	if err := receive(t, deliveries).Nack(false, true); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	msg := receive(t, deliveries)
+	if string(msg.Body) != `{"id":"e-1"}` {
+		t.Fatalf("unexpected requeued body %s", msg.Body)
+	}
+	msg.Ack(false)
+}
+
+func TestRedisQueueDelayedPublish(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q := newTestRedisQueue(t, mr.Addr(), "c-1")
+	ctx := context.Background()
+
+	published := time.Now()
+	if err := q.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{Delay: 200}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if n, _ := q.rdb.ZCard(ctx, q.opts.DelayedKey).Result(); n != 1 {
+		t.Fatalf("delayed set holds %d messages, want 1", n)
+	}
+
+	msg := receive(t, q.Listen())
+	if elapsed := time.Since(published); elapsed < 200*time.Millisecond {
+		t.Fatalf("delayed message delivered after %s", elapsed)
+	}
+	if n, _ := q.rdb.ZCard(ctx, q.opts.DelayedKey).Result(); n != 0 {
+		t.Fatalf("delayed set holds %d messages after promotion", n)
+	}
+	msg.Ack(false)
+}
+
+// TestRedisQueueHeartbeat holds a delivery for several ClaimMinIdle, the
+// heartbeat must keep another consumer from reclaiming it.
+func TestRedisQueueHeartbeat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	holder := newTestRedisQueue(t, mr.Addr(), "c-holder")
+	ctx := context.Background()
+
+	if err := holder.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	msg := receive(t, holder.Listen())
+	time.Sleep(3 * holder.opts.ClaimMinIdle)
+
+	other := newTestRedisQueue(t, mr.Addr(), "c-other")
+	select {
+	case reclaimed := <-other.Listen():
+		t.Fatalf("message reclaimed while in progress: %+v", reclaimed)
+	case <-time.After(2 * holder.opts.ClaimMinIdle):
+	}
+
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	pending, err := holder.rdb.XPending(ctx, "webhooks", "webhooks").Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatalf("pending = %+v (%v), want none", pending, err)
+	}
+}
+
+// TestRedisQueueClaimPending abandons a delivery on a first consumer, a
+// second consumer must reclaim it once it has been idle for ClaimMinIdle.
+func TestRedisQueueClaimPending(t *testing.T) {
+	mr := miniredis.RunT(t)
+	dead := newTestRedisQueue(t, mr.Addr(), "c-dead")
+	ctx := context.Background()
+
+	if err := dead.Publish(ctx, []byte(`{"id":"e-1"}`), ports.QueuePortPublishOpts{}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	receive(t, dead.Listen())
+	dead.Close()
+	time.Sleep(2 * dead.opts.ClaimMinIdle)
+
+	alive := newTestRedisQueue(t, mr.Addr(), "c-alive")
+	msg := receive(t, alive.Listen())
+	if string(msg.Body) != `{"id":"e-1"}` || !msg.Redelivered {
+		t.Fatalf("unexpected delivery %+v, want the abandoned message redelivered", msg)
+	}
+	if err := msg.Ack(false); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	pending, err := alive.rdb.XPending(ctx, "webhooks", "webhooks").Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatalf("pending = %+v (%v), want none", pending, err)
+	}
+}
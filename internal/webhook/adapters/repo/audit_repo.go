@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/gorm"
+)
+
+// AuditRepo appends to audit_log, the table rejects updates and deletes.
+// Entries saved within a transaction of the repos of this package are
+// committed with it.
+type AuditRepo struct {
+	db *gorm.DB
+}
+
+func NewAuditRepo(db *gorm.DB) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+func (r *AuditRepo) SaveAuditEntry(ctx context.Context, entry *model.AuditEntry) error {
+	return dbFromContext(ctx, r.db).Create(entry).Error
+}
+
+func (r *AuditRepo) ListAuditEntries(ctx context.Context, filter model.AuditFilter) (model.AuditPage, error) {
+	query := tenantScoped(ctx, r.db.WithContext(ctx)).Model(&model.AuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		query = query.Where("target_id = ?", filter.TargetId)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.BeforeId != 0 {
+		query = query.Where("id < ?", filter.BeforeId)
+	}
+
+	limit := filter.PageLimit()
+	var entries []model.AuditEntry
+	// one extra row tells if there is a next page
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&entries).Error; err != nil {
+		return model.AuditPage{}, err
+	}
+
+	return newAuditPage(entries, limit), nil
+}
+
+func newAuditPage(entries []model.AuditEntry, limit int) model.AuditPage {
+	if len(entries) <= limit {
+		return model.AuditPage{Entries: entries}
+	}
+
+	entries = entries[:limit]
+	return model.AuditPage{
+		Entries:    entries,
+		NextCursor: strconv.FormatInt(entries[limit-1].Id, 10),
+	}
+}
@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+func saveAuditEntry(t *testing.T, r *AuditRepo, ctx context.Context, tenantId string) *model.AuditEntry {
+	t.Helper()
+	target := model.AuditTarget{Type: model.AuditTargetTenant, Id: tenantId, TenantId: tenantId}
+	entry, err := model.NewAuditEntry(ctx, model.AuditActionTenantStatus, target,
+		map[string]string{"status": "active"}, map[string]string{"status": "disabled"})
+	if err != nil {
+		t.Fatalf("new entry: %v", err)
+	}
+	if err := r.SaveAuditEntry(ctx, entry); err != nil {
+		t.Fatalf("save entry: %v", err)
+	}
+	return entry
+}
+
+func TestAuditLogAppendOnly(t *testing.T) {
+	db := newTestDB(t)
+	entry := saveAuditEntry(t, NewAuditRepo(db), context.Background(), "tenant-a")
+
+	if err := db.Exec("UPDATE audit_log SET actor = ? WHERE id = ?", "someone", entry.Id).Error; err == nil || !strings.Contains(err.Error(), "append only") {
+		t.Errorf("update = %v, want the append only error", err)
+	}
+	if err := db.Exec("DELETE FROM audit_log WHERE id = ?", entry.Id).Error; err == nil || !strings.Contains(err.Error(), "append only") {
+		t.Errorf("delete = %v, want the append only error", err)
+	}
+
+	var count int64
+	if err := db.Table("audit_log").Where("id = ? AND actor = ?", entry.Id, entry.Actor).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("entry after rejected changes: count %d %v", count, err)
+	}
+}
+
+func TestListAuditEntriesTenantScope(t *testing.T) {
+	r := NewAuditRepo(newTestDB(t))
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		saveAuditEntry(t, r, context.Background(), tenant)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{name: "tenant", ctx: model.WithTenant(context.Background(), "tenant-a"), want: []string{"tenant-a"}},
+		{name: "no scope", ctx: context.Background()},
+		{name: "all tenants", ctx: model.AllTenants(context.Background()), want: []string{"tenant-b", "tenant-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := r.ListAuditEntries(tt.ctx, model.AuditFilter{})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			var got []string
+			for _, entry := range page.Entries {
+				got = append(got, entry.TenantId)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("listed tenants %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTenantChangeCommitsWithAudit checks a tenant change and its audit
+// entry are rolled back together.
+func TestTenantChangeCommitsWithAudit(t *testing.T) {
+	db := newTestDB(t)
+	tenants := NewTenantRepo(db)
+	audit := NewAuditRepo(db)
+	ctx := model.AllTenants(context.Background())
+	if _, err := tenants.CreateTenant(ctx, model.Tenant{Id: "tenant-a", Name: "A"}); err != nil {
+		t.Fatalf("create tenant: %v", err)
+	}
+
+	errAudit := errors.New("audit failed")
+	err := tenants.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := tenants.UpdateTenantStatus(ctx, "tenant-a", model.TenantStatusDisabled); err != nil {
+			return err
+		}
+		saveAuditEntry(t, audit, ctx, "tenant-a")
+		return errAudit
+	})
+	if !errors.Is(err, errAudit) {
+		t.Fatalf("transaction = %v, want the audit error", err)
+	}
+
+	tenant, err := tenants.GetTenant(ctx, "tenant-a")
+	if err != nil || !tenant.IsActive() {
+		t.Errorf("tenant after rollback = %+v %v, want active", tenant, err)
+	}
+	if page, err := audit.ListAuditEntries(ctx, model.AuditFilter{}); err != nil || len(page.Entries) != 0 {
+		t.Errorf("entries after rollback = %d %v, want none", len(page.Entries), err)
+	}
+
+	err = tenants.WithinTransaction(ctx, func(ctx context.Context) error {
+		saveAuditEntry(t, audit, ctx, "tenant-a")
+		return tenants.UpdateTenantStatus(ctx, "tenant-a", model.TenantStatusDisabled)
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if page, err := audit.ListAuditEntries(ctx, model.AuditFilter{}); err != nil || len(page.Entries) != 1 {
+		t.Errorf("entries after commit = %d %v, want 1", len(page.Entries), err)
+	}
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeliveryStatsRepo stores the attempts in delivery_attempts, partitioned
+// by month on postgres, and their rollups in webhook_delivery_stats.
+type DeliveryStatsRepo struct {
+	db *gorm.DB
+}
+
+func NewDeliveryStatsRepo(db *gorm.DB) *DeliveryStatsRepo {
+	return &DeliveryStatsRepo{db: db}
+}
+
+func (r *DeliveryStatsRepo) SaveDeliveryAttempt(ctx context.Context, attempt *model.DeliveryAttempt) error {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Create(attempt).Error
+}
+
+func (r *DeliveryStatsRepo) ListDeliveryAttempts(ctx context.Context, from time.Time, to time.Time) ([]model.DeliveryAttempt, error) {
+	var attempts []model.DeliveryAttempt
+	err := r.scoped(ctx).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("created_at").
+		Find(&attempts).Error
+	return attempts, err
+}
+
+func (r *DeliveryStatsRepo) ListDeliveryStats(ctx context.Context, filter model.DeliveryStatsFilter) ([]model.WebhookDeliveryStats, error) {
+	query := r.scoped(ctx).
+		Where("granularity = ? AND bucket_start >= ? AND bucket_start < ?", filter.Granularity, filter.From, filter.To)
+	if filter.WebhookId != 0 {
+		query = query.Where("webhook_id = ?", filter.WebhookId)
+	}
+
+	var stats []model.WebhookDeliveryStats
+	err := query.Order("webhook_id, bucket_start").Find(&stats).Error
+	return stats, err
+}
+
+func (r *DeliveryStatsRepo) UpsertDeliveryStats(ctx context.Context, stats []model.WebhookDeliveryStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for i := range stats {
+		stats[i].UpdatedAt = now
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "webhook_id"}, {Name: "granularity"}, {Name: "bucket_start"}},
+		UpdateAll: true,
+	}).CreateInBatches(stats, 500).Error
+}
+
+func (r *DeliveryStatsRepo) DeleteDeliveryStats(ctx context.Context, granularity model.StatsGranularity, before time.Time) (int64, error) {
+	res := r.scoped(ctx).
+		Where("granularity = ? AND bucket_start < ?", granularity, before).
+		Delete(&model.WebhookDeliveryStats{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *DeliveryStatsRepo) scoped(ctx context.Context) *gorm.DB {
+	return tenantScoped(ctx, r.db.WithContext(ctx))
+}
@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"gorm.io/datatypes"
+)
+
+// ENCRYPTED_SEARCH_MAX_PAGES bounds the pages read by one payload search
+const ENCRYPTED_SEARCH_MAX_PAGES = 10
+
+// EncryptedWebhookRepo decrypts webhook secrets and event payloads, response
+// bodies and last errors on read and encrypts what it writes, the service
+// only ever sees plaintext. Values stored before encryption was enabled are
+// read as is.
+type EncryptedWebhookRepo struct {
+	ports.WebhookRepositoryPort
+	encryptor *Encryptor
+}
+
+func NewEncryptedWebhookRepo(repo ports.WebhookRepositoryPort, encryptor *Encryptor) *EncryptedWebhookRepo {
+	return &EncryptedWebhookRepo{WebhookRepositoryPort: repo, encryptor: encryptor}
+}
+
+func (r *EncryptedWebhookRepo) GetWebhookByID(ctx context.Context, id int) (*model.Webhook, error) {
+	webhook, err := r.WebhookRepositoryPort.GetWebhookByID(ctx, id)
+	if err != nil || webhook == nil {
+		return webhook, err
+	}
+
+	webhook.Secret, err = r.encryptor.DecryptString(ctx, fieldAAD("webhooks", webhook.Id, "secret"), webhook.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (r *EncryptedWebhookRepo) GetWebhookEventByID(ctx context.Context, id string) (*model.WebhookEvent, error) {
+	event, err := r.WebhookRepositoryPort.GetWebhookEventByID(ctx, id)
+	if err != nil || event == nil {
+		return event, err
+	}
+
+	if err := r.decryptEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (r *EncryptedWebhookRepo) UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) error {
+	stored := *event
+	if err := r.EncryptWebhookEvent(ctx, &stored); err != nil {
+		return err
+	}
+
+	if err := r.WebhookRepositoryPort.UpdateWebhookEventById(ctx, id, &stored); err != nil {
+		return err
+	}
+
+	event.UpdatedAt = stored.UpdatedAt
+	event.Version = stored.Version
+	return nil
+}
+
+func (r *EncryptedWebhookRepo) UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) error {
+	stored := *event
+	if err := r.EncryptWebhookEvent(ctx, &stored); err != nil {
+		return err
+	}
+
+	if err := r.WebhookRepositoryPort.UpdateWebhookEventPayload(ctx, &stored); err != nil {
+		return err
+	}
+
+	event.UpdatedAt = stored.UpdatedAt
+	event.Version = stored.Version
+	return nil
+}
+
+// EncryptStoredWebhookEvent rewrites every encrypted column of an event
+// stored in plaintext as one unit, both writes check the version.
+func (r *EncryptedWebhookRepo) EncryptStoredWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	return r.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := r.UpdateWebhookEventById(ctx, event.Id, event); err != nil {
+			return err
+		}
+		return r.UpdateWebhookEventPayload(ctx, event)
+	})
+}
+
+func (r *EncryptedWebhookRepo) ListStuckEvents(ctx context.Context, pendingBefore time.Time, leaseExpiredBefore time.Time, limit int) ([]model.WebhookEvent, error) {
+	events, err := r.WebhookRepositoryPort.ListStuckEvents(ctx, pendingBefore, leaseExpiredBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		if err := r.decryptEvent(ctx, &events[i]); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// ListWebhookEvents can't let the database match encrypted payloads, those
+// filters are applied after decryption while scanning at most
+// ENCRYPTED_SEARCH_MAX_PAGES pages. The cursor returned points at the last
+// scanned event so the caller continues where the scan stopped.
+func (r *EncryptedWebhookRepo) ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error) {
+	payloadFilter := model.WebhookEventFilter{PayloadFields: filter.PayloadFields}
+	filter.PayloadFields = nil
+
+	limit := filter.PageLimit()
+	page := model.WebhookEventPage{Events: []model.WebhookEvent{}}
+	for range ENCRYPTED_SEARCH_MAX_PAGES {
+		scanned, err := r.WebhookRepositoryPort.ListWebhookEvents(ctx, filter)
+		if err != nil {
+			return page, err
+		}
+
+		for i := range scanned.Events {
+			event := scanned.Events[i]
+			if err := r.decryptEvent(ctx, &event); err != nil {
+				return page, err
+			}
+			if !payloadFilter.MatchesPayload(event.Payload.Data()) {
+				continue
+			}
+
+			page.Events = append(page.Events, event)
+			if len(page.Events) == limit {
+				if i < len(scanned.Events)-1 || scanned.NextCursor != "" {
+					page.NextCursor = model.CursorOf(&event).Encode()
+				}
+				return page, nil
+			}
+		}
+
+		page.NextCursor = scanned.NextCursor
+		if scanned.NextCursor == "" {
+			return page, nil
+		}
+		filter.Cursor, err = model.DecodeWebhookEventCursor(scanned.NextCursor)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	return page, nil
+}
+
+// EncryptWebhook encrypts the secret in place, for code paths that create
+// webhooks. The id is part of the ciphertext, it must be assigned already.
+func (r *EncryptedWebhookRepo) EncryptWebhook(ctx context.Context, webhook *model.Webhook) (err error) {
+	if IsEncrypted(webhook.Secret) {
+		return nil
+	}
+
+	webhook.Secret, err = r.encryptor.EncryptString(ctx, webhookScope(webhook.Id), fieldAAD("webhooks", webhook.Id, "secret"), webhook.Secret)
+	return err
+}
+
+// EncryptWebhookEvent encrypts the payload, response body and last error in
+// place, already encrypted fields are kept.
+func (r *EncryptedWebhookRepo) EncryptWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	scope := webhookScope(event.WebhookId)
+	for _, field := range eventFields(event) {
+		encrypted, err := r.encryptor.EncryptObject(ctx, scope, fieldAAD("webhook_events", event.Id, field.column), field.value.Data())
+		if err != nil {
+			return err
+		}
+		*field.value = datatypes.NewJSONType(encrypted)
+	}
+	return nil
+}
+
+func (r *EncryptedWebhookRepo) decryptEvent(ctx context.Context, event *model.WebhookEvent) error {
+	for _, field := range eventFields(event) {
+		decrypted, err := r.encryptor.DecryptObject(ctx, fieldAAD("webhook_events", event.Id, field.column), field.value.Data())
+		if err != nil {
+			return err
+		}
+		*field.value = datatypes.NewJSONType(decrypted)
+	}
+	return nil
+}
+
+type encryptedField struct {
+	column string
+	value  *datatypes.JSONType[model.Object]
+}
+
+func eventFields(event *model.WebhookEvent) []encryptedField {
+	return []encryptedField{
+		{column: "payload", value: &event.Payload},
+		{column: "response_body", value: &event.ResponseBody},
+		{column: "last_error", value: &event.LastError},
+	}
+}
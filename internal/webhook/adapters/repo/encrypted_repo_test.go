@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/webhook-processor/internal/shared/crypto"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func newTestEncryptedRepo(t *testing.T, db *gorm.DB) *EncryptedWebhookRepo {
+	t.Helper()
+	kms, err := crypto.NewLocalKMS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedWebhookRepo(NewWebhookRepo(db), NewEncryptor(NewDataKeyRepo(db), kms))
+}
+
+// rawColumn reads a column of an event as stored, without decryption.
+func rawColumn(t *testing.T, db *gorm.DB, id string, column string) model.Object {
+	t.Helper()
+	var raw string
+	if err := db.Table("webhook_events").Select(column).Where("id = ?", id).Row().Scan(&raw); err != nil {
+		t.Fatalf("read %s: %v", column, err)
+	}
+	obj := model.Object{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		t.Fatalf("decode %s %q: %v", column, raw, err)
+	}
+	return obj
+}
+
+func TestEncryptExistingPersistsCiphertext(t *testing.T) {
+	db := newTestDB(t)
+	repo := newTestEncryptedRepo(t, db)
+	ctx := context.Background()
+
+	if err := db.Create(&model.Webhook{Id: 1, TenantId: model.DEFAULT_TENANT_ID, CallbackURL: "http://localhost", Secret: "s3cret", Status: model.WebhookStatusActive}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"event-1", "event-2", "event-3"} {
+		err := db.Create(&model.WebhookEvent{
+			Id:           id,
+			TenantId:     model.DEFAULT_TENANT_ID,
+			WebhookId:    1,
+			EventType:    "order.created",
+			Payload:      datatypes.NewJSONType(model.Object{"card": "4242"}),
+			ResponseBody: datatypes.NewJSONType(model.Object{"echo": "4242"}),
+			Status:       model.WebhookEventsStatusDelivered,
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhooks, events, err := EncryptExisting(ctx, db, repo, 2)
+	if err != nil {
+		t.Fatalf("encrypt existing: %v", err)
+	}
+	if webhooks != 1 || events != 3 {
+		t.Fatalf("encrypted %d webhooks and %d events, want 1 and 3", webhooks, events)
+	}
+
+	for _, column := range []string{"payload", "response_body"} {
+		if stored := rawColumn(t, db, "event-1", column); !IsEncryptedObject(stored) {
+			t.Fatalf("%s stored as %v, want ciphertext", column, stored)
+		}
+	}
+	var secret string
+	db.Table("webhooks").Select("secret").Where("id = 1").Row().Scan(&secret)
+	if !IsEncrypted(secret) {
+		t.Fatalf("secret stored as %q, want ciphertext", secret)
+	}
+
+	event, err := repo.GetWebhookEventByID(model.AllTenants(ctx), "event-1")
+	if err != nil || event == nil {
+		t.Fatalf("get event: %v %v", event, err)
+	}
+	if event.Payload.Data()["card"] != "4242" || event.ResponseBody.Data()["echo"] != "4242" {
+		t.Fatalf("decrypted event %+v", event)
+	}
+
+	// a second run finds nothing left
+	if webhooks, events, err := EncryptExisting(ctx, db, repo, 2); err != nil || webhooks != 0 || events != 0 {
+		t.Fatalf("second run encrypted %d webhooks and %d events: %v", webhooks, events, err)
+	}
+}
+
+// conflictingRepo loses every payload write to a concurrent update.
+type conflictingRepo struct {
+	*WebhookRepo
+}
+
+func (r conflictingRepo) UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) error {
+	return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+}
+
+func TestEncryptExistingStopsWithoutProgress(t *testing.T) {
+	db := newTestDB(t)
+	kms, err := crypto.NewLocalKMS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewEncryptedWebhookRepo(conflictingRepo{NewWebhookRepo(db)}, NewEncryptor(NewDataKeyRepo(db), kms))
+
+	db.Create(&model.Webhook{Id: 1, TenantId: model.DEFAULT_TENANT_ID, CallbackURL: "http://localhost", Secret: "s3cret", Status: model.WebhookStatusActive})
+	db.Create(&model.WebhookEvent{Id: "event-1", TenantId: model.DEFAULT_TENANT_ID, WebhookId: 1, EventType: "order.created", Payload: datatypes.NewJSONType(model.Object{"card": "4242"}), Status: model.WebhookEventsStatusPending})
+
+	_, events, err := EncryptExisting(context.Background(), db, repo, 10)
+	if !errors.Is(err, ErrEncryptionStalled) || events != 0 {
+		t.Fatalf("encrypt existing = %d events, %v, want %v", events, err, ErrEncryptionStalled)
+	}
+	if stored := rawColumn(t, db, "event-1", "payload"); IsEncryptedObject(stored) {
+		t.Fatal("payload encrypted although the write conflicted")
+	}
+}
@@ -0,0 +1,326 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webhook-processor/internal/shared/crypto"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ENCRYPTED_PREFIX marks encrypted strings: enc:v1:<data key id>:<base64>
+const ENCRYPTED_PREFIX = "enc:v1:"
+
+// ENCRYPTED_OBJECT_KEY holds the encrypted string of a JSON object, the
+// column stays valid JSONB
+const ENCRYPTED_OBJECT_KEY = "$enc"
+
+var ErrMalformedCiphertext = errors.New("malformed ciphertext")
+
+type DataKey struct {
+	Id          int64
+	Scope       string
+	WrappedKey  []byte
+	MasterKeyId string
+	CreatedAt   time.Time
+	RotatedAt   time.Time
+}
+
+type DataKeyStore interface {
+	GetDataKey(ctx context.Context, id int64) (*DataKey, error)
+	GetDataKeyByScope(ctx context.Context, scope string) (*DataKey, error)
+	// CreateDataKey keeps the existing key when scope already has one and
+	// returns the stored key
+	CreateDataKey(ctx context.Context, key DataKey) (*DataKey, error)
+	ListDataKeysNotWrappedBy(ctx context.Context, masterKeyId string, limit int) ([]DataKey, error)
+	// RewrapDataKey replaces the wrapped key if it is still wrapped by
+	// previousMasterKeyId
+	RewrapDataKey(ctx context.Context, id int64, previousMasterKeyId string, masterKeyId string, wrapped []byte) error
+}
+
+type DataKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewDataKeyRepo(db *gorm.DB) *DataKeyRepo {
+	return &DataKeyRepo{db: db}
+}
+
+func (r *DataKeyRepo) GetDataKey(ctx context.Context, id int64) (*DataKey, error) {
+	var key DataKey
+	if err := r.db.WithContext(ctx).First(&key, "id = ?", id).Error; err != nil {
+		return nil, notFoundAsNil(err)
+	}
+	return &key, nil
+}
+
+func (r *DataKeyRepo) GetDataKeyByScope(ctx context.Context, scope string) (*DataKey, error) {
+	var key DataKey
+	if err := r.db.WithContext(ctx).First(&key, "scope = ?", scope).Error; err != nil {
+		return nil, notFoundAsNil(err)
+	}
+	return &key, nil
+}
+
+func (r *DataKeyRepo) CreateDataKey(ctx context.Context, key DataKey) (*DataKey, error) {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "scope"}}, DoNothing: true}).
+		Omit("rotated_at").
+		Create(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.GetDataKeyByScope(ctx, key.Scope)
+}
+
+func (r *DataKeyRepo) ListDataKeysNotWrappedBy(ctx context.Context, masterKeyId string, limit int) ([]DataKey, error) {
+	var keys []DataKey
+	err := r.db.WithContext(ctx).
+		Where("master_key_id <> ?", masterKeyId).
+		Order("id").
+		Limit(limit).
+		Find(&keys).Error
+	return keys, err
+}
+
+func (r *DataKeyRepo) RewrapDataKey(ctx context.Context, id int64, previousMasterKeyId string, masterKeyId string, wrapped []byte) error {
+	return r.db.WithContext(ctx).Model(&DataKey{}).
+		Where("id = ? AND master_key_id = ?", id, previousMasterKeyId).
+		Updates(map[string]interface{}{
+			"wrapped_key":   wrapped,
+			"master_key_id": masterKeyId,
+			"rotated_at":    time.Now(),
+		}).Error
+}
+
+// Encryptor applies envelope encryption: values are sealed with AES-GCM
+// under a data key per scope, data keys are wrapped by the provider's
+// master key. Unwrapped data keys are cached for the process lifetime.
+type Encryptor struct {
+	store    DataKeyStore
+	provider crypto.KeyProvider
+
+	mu     sync.RWMutex
+	keys   map[int64][]byte
+	scopes map[string]int64
+}
+
+func NewEncryptor(store DataKeyStore, provider crypto.KeyProvider) *Encryptor {
+	return &Encryptor{
+		store:    store,
+		provider: provider,
+		keys:     map[int64][]byte{},
+		scopes:   map[string]int64{},
+	}
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ENCRYPTED_PREFIX)
+}
+
+func IsEncryptedObject(obj model.Object) bool {
+	value, ok := obj[ENCRYPTED_OBJECT_KEY].(string)
+	return ok && len(obj) == 1 && IsEncrypted(value)
+}
+
+// EncryptString seals plaintext under the data key of scope, aad must be
+// given again to decrypt it.
+func (e *Encryptor) EncryptString(ctx context.Context, scope string, aad string, plaintext string) (string, error) {
+	id, key, err := e.scopeKey(ctx, scope)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := crypto.Seal(key, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%d:%s", ENCRYPTED_PREFIX, id, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// DecryptString returns values written before encryption was enabled as is.
+func (e *Encryptor) DecryptString(ctx context.Context, aad string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	idPart, encoded, ok := strings.Cut(strings.TrimPrefix(value, ENCRYPTED_PREFIX), ":")
+	if !ok {
+		return "", ErrMalformedCiphertext
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return "", ErrMalformedCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrMalformedCiphertext
+	}
+
+	key, err := e.dataKey(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := crypto.Open(key, sealed, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (e *Encryptor) EncryptObject(ctx context.Context, scope string, aad string, obj model.Object) (model.Object, error) {
+	if obj == nil || IsEncryptedObject(obj) {
+		return obj, nil
+	}
+
+	plaintext, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.EncryptString(ctx, scope, aad, string(plaintext))
+	if err != nil {
+		return nil, err
+	}
+
+	return model.Object{ENCRYPTED_OBJECT_KEY: value}, nil
+}
+
+func (e *Encryptor) DecryptObject(ctx context.Context, aad string, obj model.Object) (model.Object, error) {
+	if !IsEncryptedObject(obj) {
+		return obj, nil
+	}
+
+	plaintext, err := e.DecryptString(ctx, aad, obj[ENCRYPTED_OBJECT_KEY].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	var decrypted model.Object
+	if err := json.Unmarshal([]byte(plaintext), &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+// Rekey wraps every data key again with the current master key, the data
+// itself is untouched. It returns how many keys were rewrapped.
+func (e *Encryptor) Rekey(ctx context.Context, batchSize int) (int, error) {
+	current := e.provider.CurrentKeyId()
+	rewrapped := 0
+	for {
+		keys, err := e.store.ListDataKeysNotWrappedBy(ctx, current, batchSize)
+		if err != nil {
+			return rewrapped, err
+		}
+		if len(keys) == 0 {
+			return rewrapped, nil
+		}
+
+		for _, dataKey := range keys {
+			key, err := e.provider.UnwrapKey(ctx, dataKey.MasterKeyId, dataKey.WrappedKey)
+			if err != nil {
+				return rewrapped, fmt.Errorf("unwrap data key %d: %w", dataKey.Id, err)
+			}
+			wrapped, err := e.provider.WrapKey(ctx, current, key)
+			if err != nil {
+				return rewrapped, fmt.Errorf("wrap data key %d: %w", dataKey.Id, err)
+			}
+			if err := e.store.RewrapDataKey(ctx, dataKey.Id, dataKey.MasterKeyId, current, wrapped); err != nil {
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+	}
+}
+
+func (e *Encryptor) scopeKey(ctx context.Context, scope string) (int64, []byte, error) {
+	e.mu.RLock()
+	id, ok := e.scopes[scope]
+	key := e.keys[id]
+	e.mu.RUnlock()
+	if ok {
+		return id, key, nil
+	}
+
+	dataKey, err := e.store.GetDataKeyByScope(ctx, scope)
+	if err != nil {
+		return 0, nil, err
+	}
+	if dataKey == nil {
+		if dataKey, err = e.createDataKey(ctx, scope); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	key, err = e.dataKey(ctx, dataKey.Id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	e.mu.Lock()
+	e.scopes[scope] = dataKey.Id
+	e.mu.Unlock()
+	return dataKey.Id, key, nil
+}
+
+func (e *Encryptor) createDataKey(ctx context.Context, scope string) (*DataKey, error) {
+	key, err := crypto.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	masterKeyId := e.provider.CurrentKeyId()
+	wrapped, err := e.provider.WrapKey(ctx, masterKeyId, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// a concurrent writer may win, the stored key is the one to use
+	return e.store.CreateDataKey(ctx, DataKey{Scope: scope, WrappedKey: wrapped, MasterKeyId: masterKeyId})
+}
+
+func (e *Encryptor) dataKey(ctx context.Context, id int64) ([]byte, error) {
+	e.mu.RLock()
+	key, ok := e.keys[id]
+	e.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	dataKey, err := e.store.GetDataKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey == nil {
+		return nil, fmt.Errorf("data key %d not found", id)
+	}
+
+	key, err = e.provider.UnwrapKey(ctx, dataKey.MasterKeyId, dataKey.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.keys[id] = key
+	e.mu.Unlock()
+	return key, nil
+}
+
+func webhookScope(webhookId int) string {
+	return fmt.Sprintf("webhook:%d", webhookId)
+}
+
+func fieldAAD(table string, id interface{}, column string) string {
+	return fmt.Sprintf("%s:%v:%s", table, id, column)
+}
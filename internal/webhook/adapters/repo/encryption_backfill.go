@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/gorm"
+)
+
+// ErrEncryptionStalled stops a backfill whose batch encrypted nothing, the
+// same rows would be read again forever.
+var ErrEncryptionStalled = errors.New("encryption backfill made no progress")
+
+// EncryptExisting encrypts the rows stored before encryption was enabled,
+// in batches. Events go through the version check, a row changed by a
+// delivery meanwhile is picked up again by the next batch.
+func EncryptExisting(ctx context.Context, db *gorm.DB, repo *EncryptedWebhookRepo, batchSize int) (webhooks int, events int, err error) {
+	ctx = model.AllTenants(ctx)
+	for {
+		var batch []model.Webhook
+		err := db.WithContext(ctx).
+			Where("secret NOT LIKE ?", ENCRYPTED_PREFIX+"%").
+			Order("id").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return webhooks, events, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		progress := 0
+		for _, webhook := range batch {
+			plaintext := webhook.Secret
+			if err := repo.EncryptWebhook(ctx, &webhook); err != nil {
+				return webhooks, events, err
+			}
+			res := db.WithContext(ctx).Model(&model.Webhook{}).
+				Where("id = ? AND secret = ?", webhook.Id, plaintext).
+				Update("secret", webhook.Secret)
+			if res.Error != nil {
+				return webhooks, events, res.Error
+			}
+			progress += int(res.RowsAffected)
+		}
+		webhooks += progress
+		if progress == 0 {
+			return webhooks, events, fmt.Errorf("%w: %d webhooks left", ErrEncryptionStalled, len(batch))
+		}
+	}
+
+	// sqlite reads a key starting with $ as a JSON path, it is quoted there
+	notEncrypted, key := "payload->>? IS NULL", ENCRYPTED_OBJECT_KEY
+	if db.Dialector.Name() == "sqlite" {
+		notEncrypted, key = "json_extract(payload, ?) IS NULL", `$."`+ENCRYPTED_OBJECT_KEY+`"`
+	}
+
+	for {
+		var batch []model.WebhookEvent
+		err := db.WithContext(ctx).
+			Where(notEncrypted, key).
+			Order("created_at, id").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return webhooks, events, err
+		}
+
+		progress := 0
+		for _, event := range batch {
+			err := repo.EncryptStoredWebhookEvent(ctx, &event)
+			var conflict *model.VersionConflictError
+			if errors.As(err, &conflict) {
+				log.Info("webhook event changed while encrypting, retrying", "id", event.Id)
+				continue
+			}
+			if err != nil {
+				return webhooks, events, err
+			}
+			progress++
+		}
+		events += progress
+		if progress == 0 {
+			return webhooks, events, fmt.Errorf("%w: %d events left", ErrEncryptionStalled, len(batch))
+		}
+	}
+}
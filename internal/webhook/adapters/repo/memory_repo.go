@@ -0,0 +1,364 @@
+package repo
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+// MemoryWebhookRepo keeps tenants, webhooks and events in maps, reads and
+// updates follow the same rules as the gorm repo: they are scoped to the
+// tenant of the context, every mutable column is written and the version is
+// checked.
+type MemoryWebhookRepo struct {
+	mu sync.RWMutex
+	// txMu is held by the running transaction and by writes outside of one
+	txMu     sync.Mutex
+	tenants  map[string]model.Tenant
+	webhooks map[int]model.Webhook
+	events   map[string]model.WebhookEvent
+	locks    map[string]bool
+}
+
+func NewMemoryWebhookRepo() *MemoryWebhookRepo {
+	return &MemoryWebhookRepo{
+		tenants: map[string]model.Tenant{
+			model.DEFAULT_TENANT_ID: {Id: model.DEFAULT_TENANT_ID, Name: "Default", Status: model.TenantStatusActive},
+		},
+		webhooks: map[int]model.Webhook{},
+		events:   map[string]model.WebhookEvent{},
+		locks:    map[string]bool{},
+	}
+}
+
+func (r *MemoryWebhookRepo) SaveTenant(tenant model.Tenant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if tenant.CreatedAt.IsZero() {
+		tenant.CreatedAt = now
+	}
+	tenant.UpdatedAt = now
+	r.tenants[tenant.Id] = tenant
+}
+
+// SaveWebhook and SaveWebhookEvent put rows without a tenant in the
+// default tenant, like the column default does.
+func (r *MemoryWebhookRepo) SaveWebhook(webhook model.Webhook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if webhook.TenantId == "" {
+		webhook.TenantId = model.DEFAULT_TENANT_ID
+	}
+	now := time.Now()
+	if webhook.CreatedAt.IsZero() {
+		webhook.CreatedAt = now
+	}
+	webhook.UpdatedAt = now
+	r.webhooks[webhook.Id] = webhook
+}
+
+func (r *MemoryWebhookRepo) SaveWebhookEvent(event model.WebhookEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.TenantId == "" {
+		event.TenantId = model.DEFAULT_TENANT_ID
+	}
+	now := time.Now()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = now
+	}
+	event.UpdatedAt = now
+	r.events[event.Id] = event
+}
+
+func (r *MemoryWebhookRepo) GetTenantByID(ctx context.Context, id string) (*model.Tenant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tenant, ok := r.tenants[id]
+	if !ok || !inScope(ctx, id) {
+		return nil, nil
+	}
+	return &tenant, nil
+}
+
+func (r *MemoryWebhookRepo) GetWebhookByID(ctx context.Context, id int) (*model.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhook, ok := r.webhooks[id]
+	if !ok || !inScope(ctx, webhook.TenantId) {
+		return nil, nil
+	}
+	return &webhook, nil
+}
+
+func (r *MemoryWebhookRepo) GetWebhookEventByID(ctx context.Context, id string) (*model.WebhookEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	event, ok := r.events[id]
+	if !ok || !inScope(ctx, event.TenantId) {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+func (r *MemoryWebhookRepo) UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) error {
+	tx, done := r.beginWrite(ctx)
+	defer done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.events[id]
+	if !ok || !inScope(ctx, current.TenantId) || current.Version != event.Version {
+		return &model.VersionConflictError{Id: id, Version: event.Version}
+	}
+
+	event.UpdatedAt = time.Now()
+	event.Version++
+	copyMutableColumns(&current, event)
+	r.putEvent(tx, current)
+	return nil
+}
+
+func (r *MemoryWebhookRepo) UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) error {
+	tx, done := r.beginWrite(ctx)
+	defer done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.events[event.Id]
+	if !ok || !inScope(ctx, current.TenantId) || current.Version != event.Version {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
+
+	current.Payload = event.Payload
+	current.UpdatedAt = time.Now()
+	current.Version++
+	r.putEvent(tx, current)
+
+	event.UpdatedAt = current.UpdatedAt
+	event.Version = current.Version
+	return nil
+}
+
+func (r *MemoryWebhookRepo) ClaimWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string, until time.Time) (bool, error) {
+	tx, done := r.beginWrite(ctx)
+	defer done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.events[event.Id]
+	if !ok || !inScope(ctx, current.TenantId) || current.Version != event.Version || !current.IsDeliverable(time.Now()) {
+		return false, nil
+	}
+
+	current.Status = model.WebhookEventsStatusInFlight
+	current.LeaseOwner = owner
+	current.LeaseExpiresAt = until
+	current.UpdatedAt = time.Now()
+	current.Version++
+	r.putEvent(tx, current)
+
+	event.Status = current.Status
+	event.LeaseOwner = current.LeaseOwner
+	event.LeaseExpiresAt = current.LeaseExpiresAt
+	event.UpdatedAt = current.UpdatedAt
+	event.Version = current.Version
+	return true, nil
+}
+
+func (r *MemoryWebhookRepo) ReleaseWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string) error {
+	tx, done := r.beginWrite(ctx)
+	defer done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.events[event.Id]
+	if !ok || !inScope(ctx, current.TenantId) || current.Version != event.Version || current.LeaseOwner != owner || current.IsInFlight() {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
+
+	current.LeaseOwner = ""
+	current.LeaseExpiresAt = time.Time{}
+	current.Version++
+	r.putEvent(tx, current)
+
+	event.LeaseOwner = ""
+	event.LeaseExpiresAt = time.Time{}
+	event.Version = current.Version
+	return nil
+}
+
+func (r *MemoryWebhookRepo) ListStuckEvents(ctx context.Context, pendingBefore time.Time, leaseExpiredBefore time.Time, limit int) ([]model.WebhookEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []model.WebhookEvent{}
+	for _, event := range r.events {
+		if !inScope(ctx, event.TenantId) {
+			continue
+		}
+		stalePending := event.IsPending() && event.UpdatedAt.Before(pendingBefore)
+		if stalePending || event.LeaseExpired(leaseExpiredBefore) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].UpdatedAt.Before(events[j].UpdatedAt)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (r *MemoryWebhookRepo) ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []model.WebhookEvent{}
+	for _, event := range r.events {
+		if inScope(ctx, event.TenantId) && filter.Matches(&event) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return model.CursorOf(&events[i]).Before(&events[j])
+	})
+	limit := filter.PageLimit()
+	if len(events) > limit+1 {
+		events = events[:limit+1]
+	}
+	return newWebhookEventPage(events, limit), nil
+}
+
+func (r *MemoryWebhookRepo) TouchWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	tx, done := r.beginWrite(ctx)
+	defer done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.events[event.Id]
+	if !ok || !inScope(ctx, current.TenantId) || current.Version != event.Version {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
+
+	current.UpdatedAt = time.Now()
+	current.Version++
+	r.putEvent(tx, current)
+
+	event.UpdatedAt = current.UpdatedAt
+	event.Version = current.Version
+	return nil
+}
+
+func (r *MemoryWebhookRepo) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locks[name] {
+		return nil, false, nil
+	}
+	r.locks[name] = true
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.locks, name)
+	}, true, nil
+}
+
+// WithinTransaction runs one transaction at a time and puts back the
+// events fn wrote when it fails or panics. Writes made outside of a
+// transaction wait for the running one, like on a row lock, and are never
+// rolled back by it.
+func (r *MemoryWebhookRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		return fn(ctx)
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	tx := &memoryTx{events: map[string]model.WebhookEvent{}}
+	defer func() {
+		if p := recover(); p != nil {
+			r.rollback(tx)
+			panic(p)
+		}
+		if err != nil {
+			r.rollback(tx)
+		}
+	}()
+
+	return fn(context.WithValue(ctx, memoryTxKey{}, tx))
+}
+
+// memoryTx keeps the events written in a transaction as they were before
+// their first write
+type memoryTx struct {
+	events map[string]model.WebhookEvent
+}
+
+// memoryTxKey carries the *memoryTx of the current transaction
+type memoryTxKey struct{}
+
+// beginWrite returns the transaction of ctx, outside of one the write holds
+// the transaction lock until done is called.
+func (r *MemoryWebhookRepo) beginWrite(ctx context.Context) (tx *memoryTx, done func()) {
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		return tx, func() {}
+	}
+
+	r.txMu.Lock()
+	return nil, r.txMu.Unlock
+}
+
+// putEvent stores event, r.mu must be held.
+func (r *MemoryWebhookRepo) putEvent(tx *memoryTx, event model.WebhookEvent) {
+	if tx != nil {
+		if _, ok := tx.events[event.Id]; !ok {
+			tx.events[event.Id] = r.events[event.Id]
+		}
+	}
+	r.events[event.Id] = event
+}
+
+func (r *MemoryWebhookRepo) rollback(tx *memoryTx) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	maps.Copy(r.events, tx.events)
+}
+
+// inScope tells if a row of tenantId is visible from ctx.
+func inScope(ctx context.Context, tenantId string) bool {
+	return model.InTenantScope(ctx, tenantId)
+}
+
+// copyMutableColumns mirrors the columns written by the gorm repo on update.
+func copyMutableColumns(dst *model.WebhookEvent, src *model.WebhookEvent) {
+	dst.LastError = src.LastError
+	dst.ResponseBody = src.ResponseBody
+	dst.ResponseCode = src.ResponseCode
+	dst.Tries = src.Tries
+	dst.Status = src.Status
+	dst.LeaseOwner = src.LeaseOwner
+	dst.LeaseExpiresAt = src.LeaseExpiresAt
+	dst.FailedAt = src.FailedAt
+	dst.DeliveredAt = src.DeliveredAt
+	dst.UpdatedAt = src.UpdatedAt
+	dst.Version = src.Version
+}
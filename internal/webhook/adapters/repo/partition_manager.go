@@ -0,0 +1,392 @@
+package repo
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_PARTITION_PREMAKE_MONTHS = 3
+	DEFAULT_PARTITION_RETENTION      = 90 * 24 * time.Hour
+)
+
+var partitionNamePattern = regexp.MustCompile(`^(.+)_p(\d{6})$`)
+
+// PartitionedTable is a table partitioned by created_at month, partitions
+// are named <table>_pYYYYMM and rows without one wait in <table>_default.
+type PartitionedTable struct {
+	Name string
+	// StatusColumn enables RetentionByStatus, tables without one only use
+	// the default retention
+	StatusColumn string
+}
+
+var WEBHOOK_EVENTS_TABLE = PartitionedTable{Name: "webhook_events", StatusColumn: "status"}
+var DELIVERY_ATTEMPTS_TABLE = PartitionedTable{Name: "delivery_attempts"}
+
+type PartitionManagerOpts struct {
+	Tables []PartitionedTable
+	// PremakeMonths is how many months ahead of the current one get a
+	// partition, rows no partition covers go to the default partition
+	PremakeMonths int
+	// Retention applies to every row whose status has no specific retention
+	Retention         time.Duration
+	RetentionByStatus map[string]time.Duration
+	// ArchiveDir receives one gzip NDJSON file per archived batch
+	ArchiveDir string
+	// OnDrop runs in the transaction dropping a partition with the rows it
+	// archived, an error keeps the partition
+	OnDrop func(ctx context.Context, partition string, archived int64) error
+}
+
+type PartitionManager struct {
+	db   *gorm.DB
+	opts PartitionManagerOpts
+	now  func() time.Time
+}
+
+type MaintenanceReport struct {
+	Created  []string
+	Archived map[string]int64
+	Dropped  []string
+}
+
+func NewPartitionManager(db *gorm.DB, opts PartitionManagerOpts) *PartitionManager {
+	if len(opts.Tables) == 0 {
+		opts.Tables = []PartitionedTable{WEBHOOK_EVENTS_TABLE, DELIVERY_ATTEMPTS_TABLE}
+	}
+	if opts.PremakeMonths <= 0 {
+		opts.PremakeMonths = DEFAULT_PARTITION_PREMAKE_MONTHS
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DEFAULT_PARTITION_RETENTION
+	}
+
+	return &PartitionManager{db: db, opts: opts, now: time.Now}
+}
+
+// Run creates the upcoming partitions, then archives and drops the expired
+// ones. Rows are deleted only once they were written to the archive.
+func (m *PartitionManager) Run(ctx context.Context) (MaintenanceReport, error) {
+	report := MaintenanceReport{Archived: map[string]int64{}}
+	if err := os.MkdirAll(m.opts.ArchiveDir, 0o755); err != nil {
+		return report, err
+	}
+
+	for _, table := range m.opts.Tables {
+		created, err := m.createPartitions(ctx, table)
+		report.Created = append(report.Created, created...)
+		if err != nil {
+			return report, err
+		}
+
+		if err := m.expirePartitions(ctx, table, &report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (m *PartitionManager) createPartitions(ctx context.Context, table PartitionedTable) ([]string, error) {
+	existing, err := m.listPartitions(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	for _, partition := range existing {
+		exists[partition.name] = true
+	}
+	hasDefault, err := m.hasDefaultPartition(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []string{}
+	month := monthStart(m.now())
+	for range m.opts.PremakeMonths + 1 {
+		name := partitionName(table.Name, month)
+		next := month.AddDate(0, 1, 0)
+		if !exists[name] {
+			err := m.createPartition(ctx, table, name, month, next, hasDefault)
+			if err != nil {
+				return created, fmt.Errorf("create partition %s: %w", name, err)
+			}
+			log.Info("partition created", "partition", name)
+			created = append(created, name)
+		}
+		month = next
+	}
+
+	return created, nil
+}
+
+// createPartition creates the partition of [from, to). The default
+// partition is detached meanwhile, postgres refuses a new partition while
+// the default one holds rows of its range, and those rows are moved to it.
+func (m *PartitionManager) createPartition(ctx context.Context, table PartitionedTable, name string, from time.Time, to time.Time, hasDefault bool) error {
+	// DDL takes no bind parameters, the bounds are generated here
+	create := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		name, table.Name, from.Format(time.RFC3339), to.Format(time.RFC3339),
+	)
+	if !hasDefault {
+		return m.db.WithContext(ctx).Exec(create).Error
+	}
+
+	defaultName := table.Name + "_default"
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table.Name, defaultName)).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(create).Error; err != nil {
+			return err
+		}
+		// identity columns keep their values
+		moved := tx.Exec(fmt.Sprintf(
+			"WITH moved AS (DELETE FROM %s WHERE created_at >= ? AND created_at < ? RETURNING *) "+
+				"INSERT INTO %s OVERRIDING SYSTEM VALUE SELECT * FROM moved",
+			defaultName, table.Name,
+		), from, to)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		if moved.RowsAffected > 0 {
+			log.Info("rows moved out of the default partition", "partition", name, "rows", moved.RowsAffected)
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s DEFAULT", table.Name, defaultName)).Error
+	})
+}
+
+func (m *PartitionManager) hasDefaultPartition(ctx context.Context, table PartitionedTable) (bool, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Raw(`
+		SELECT COUNT(*)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = ? AND pg_table_is_visible(p.oid) AND c.relname = ?`, table.Name, table.Name+"_default").
+		Scan(&count).Error
+	return count > 0, err
+}
+
+func (m *PartitionManager) expirePartitions(ctx context.Context, table PartitionedTable, report *MaintenanceReport) error {
+	partitions, err := m.listPartitions(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	var errs []error
+	for _, partition := range partitions {
+		end := partition.month.AddDate(0, 1, 0)
+		expired := true
+
+		for _, group := range m.retentionGroups(table) {
+			if end.Add(group.retention).After(now) {
+				expired = false
+				continue
+			}
+
+			archived, err := m.archive(ctx, partition.name, group)
+			if err != nil {
+				return fmt.Errorf("archive %s: %w", partition.name, err)
+			}
+			if archived > 0 {
+				report.Archived[partition.name] += archived
+			}
+		}
+
+		if !expired {
+			continue
+		}
+
+		// every row is archived by now, the partition is empty. A failed
+		// drop does not stop the next ones.
+		if err := m.drop(ctx, partition.name, report.Archived[partition.name]); err != nil {
+			errs = append(errs, fmt.Errorf("drop partition %s: %w", partition.name, err))
+			continue
+		}
+		log.Info("partition dropped", "partition", partition.name)
+		report.Dropped = append(report.Dropped, partition.name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *PartitionManager) drop(ctx context.Context, partition string, archived int64) error {
+	return withinTransaction(ctx, m.db, func(ctx context.Context) error {
+		if err := dbFromContext(ctx, m.db).Exec(fmt.Sprintf("DROP TABLE %s", partition)).Error; err != nil {
+			return err
+		}
+		if m.opts.OnDrop == nil {
+			return nil
+		}
+		return m.opts.OnDrop(ctx, partition, archived)
+	})
+}
+
+type partition struct {
+	name  string
+	month time.Time
+}
+
+func (m *PartitionManager) listPartitions(ctx context.Context, table PartitionedTable) ([]partition, error) {
+	var names []string
+	err := m.db.WithContext(ctx).Raw(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = ? AND pg_table_is_visible(p.oid)`, table.Name).
+		Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := []partition{}
+	for _, name := range names {
+		match := partitionNamePattern.FindStringSubmatch(name)
+		if match == nil || match[1] != table.Name {
+			continue
+		}
+		month, err := time.Parse("200601", match[2])
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, partition{name: name, month: month})
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].month.Before(partitions[j].month)
+	})
+
+	return partitions, nil
+}
+
+// retentionGroup selects the rows of a partition sharing a retention
+type retentionGroup struct {
+	label     string
+	retention time.Duration
+	where     string
+	args      []interface{}
+}
+
+func (m *PartitionManager) retentionGroups(table PartitionedTable) []retentionGroup {
+	if table.StatusColumn == "" || len(m.opts.RetentionByStatus) == 0 {
+		return []retentionGroup{{label: "all", retention: m.opts.Retention, where: "TRUE"}}
+	}
+
+	groups := []retentionGroup{}
+	statuses := []string{}
+	for status, retention := range m.opts.RetentionByStatus {
+		statuses = append(statuses, status)
+		groups = append(groups, retentionGroup{
+			label:     status,
+			retention: retention,
+			where:     table.StatusColumn + " = ?",
+			args:      []interface{}{status},
+		})
+	}
+
+	return append(groups, retentionGroup{
+		label:     "other",
+		retention: m.opts.Retention,
+		where:     "(" + table.StatusColumn + " NOT IN ? OR " + table.StatusColumn + " IS NULL)",
+		args:      []interface{}{statuses},
+	})
+}
+
+// archive deletes the rows of group and writes them to a gzip NDJSON file
+// in the same transaction, nothing is deleted when the file can't be
+// written. The file is written under a temporary name and only takes its
+// final name once the delete is committed.
+func (m *PartitionManager) archive(ctx context.Context, partition string, group retentionGroup) (int64, error) {
+	path := filepath.Join(m.opts.ArchiveDir, fmt.Sprintf("%s.%s.%d.ndjson.gz",
+		partition, strings.ReplaceAll(group.label, "/", "_"), m.now().Unix()))
+	tmp := path + ".tmp"
+
+	var archived int64
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rows, err := tx.Raw(fmt.Sprintf(
+			"DELETE FROM %s t WHERE %s RETURNING row_to_json(t)::text",
+			partition, group.where,
+		), group.args...).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		archived, err = writeNDJSON(tmp, func(write func(line string) error) error {
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					return err
+				}
+				if err := write(line); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		})
+		return err
+	})
+	if err != nil || archived == 0 {
+		// rolled back or nothing to keep
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	// the rows are gone, the temporary file is kept when the rename fails
+	if err := os.Rename(tmp, path); err != nil {
+		return archived, fmt.Errorf("rows archived in %s: %w", tmp, err)
+	}
+	log.Info("rows archived", "partition", partition, "group", group.label, "rows", archived, "file", path)
+	return archived, nil
+}
+
+// writeNDJSON writes the lines produced to a gzip file at path and syncs it.
+func writeNDJSON(path string, produce func(write func(line string) error) error) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var lines int64
+	gz := gzip.NewWriter(file)
+	err = produce(func(line string) error {
+		lines++
+		_, err := gz.Write([]byte(line + "\n"))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return lines, nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionName(table string, month time.Time) string {
+	return fmt.Sprintf("%s_p%s", table, month.Format("200601"))
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// TENANT_API_TOKEN_PREFIX makes tenant tokens easy to spot in logs and
+// secret scanners
+const TENANT_API_TOKEN_PREFIX = "whp_"
+
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// TenantRepo manages tenants, it is used by the admin tooling and to
+// authenticate API calls. Webhooks and events are read through WebhookRepo.
+type TenantRepo struct {
+	db *gorm.DB
+}
+
+func NewTenantRepo(db *gorm.DB) *TenantRepo {
+	return &TenantRepo{db: db}
+}
+
+func (r *TenantRepo) CreateTenant(ctx context.Context, tenant model.Tenant) (*model.Tenant, error) {
+	if err := tenant.Settings.Data().Validate(); err != nil {
+		return nil, err
+	}
+	if tenant.Status == "" {
+		tenant.Status = model.TenantStatusActive
+	}
+
+	if err := r.getDb(ctx).Create(&tenant).Error; err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// GetTenant returns nil when the tenant does not exist.
+func (r *TenantRepo) GetTenant(ctx context.Context, id string) (*model.Tenant, error) {
+	var tenant model.Tenant
+	if err := r.getDb(ctx).First(&tenant, "id = ?", id).Error; err != nil {
+		return nil, notFoundAsNil(err)
+	}
+	return &tenant, nil
+}
+
+func (r *TenantRepo) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	var tenants []model.Tenant
+	err := r.getDb(ctx).Order("id").Find(&tenants).Error
+	return tenants, err
+}
+
+func (r *TenantRepo) UpdateTenantSettings(ctx context.Context, id string, settings model.TenantSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	return r.update(ctx, id, map[string]interface{}{"settings": datatypes.NewJSONType(settings)})
+}
+
+func (r *TenantRepo) UpdateTenantStatus(ctx context.Context, id string, status model.TenantStatus) error {
+	return r.update(ctx, id, map[string]interface{}{"status": status})
+}
+
+// IssueAPIToken replaces the API token of a tenant, only its hash is
+// stored so the token is returned once.
+func (r *TenantRepo) IssueAPIToken(ctx context.Context, id string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	token := TENANT_API_TOKEN_PREFIX + hex.EncodeToString(secret)
+	if err := r.update(ctx, id, map[string]interface{}{"api_token_hash": hashAPIToken(token)}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetTenantByAPIToken returns nil when no tenant owns token.
+func (r *TenantRepo) GetTenantByAPIToken(ctx context.Context, token string) (*model.Tenant, error) {
+	var tenant model.Tenant
+	if err := r.getDb(ctx).First(&tenant, "api_token_hash = ?", hashAPIToken(token)).Error; err != nil {
+		return nil, notFoundAsNil(err)
+	}
+	return &tenant, nil
+}
+
+func (r *TenantRepo) update(ctx context.Context, id string, columns map[string]interface{}) error {
+	columns["updated_at"] = time.Now()
+	res := r.getDb(ctx).Model(&model.Tenant{}).Where("id = ?", id).Updates(columns)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+// WithinTransaction runs fn in a transaction, the tenant and audit repos
+// called with the context given to fn join it.
+func (r *TenantRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withinTransaction(ctx, r.db, fn)
+}
+
+func (r *TenantRepo) getDb(ctx context.Context) *gorm.DB {
+	return dbFromContext(ctx, r.db)
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
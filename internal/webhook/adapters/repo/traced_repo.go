@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/webhook-processor/internal/shared/tracing"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedWebhookRepo opens a client span around every repository call, it
+// wraps the other decorators so the spans include decryption.
+type TracedWebhookRepo struct {
+	ports.WebhookRepositoryPort
+}
+
+func NewTracedWebhookRepo(repo ports.WebhookRepositoryPort) *TracedWebhookRepo {
+	return &TracedWebhookRepo{WebhookRepositoryPort: repo}
+}
+
+func (r *TracedWebhookRepo) GetTenantByID(ctx context.Context, id string) (tenant *model.Tenant, err error) {
+	ctx, span := startRepoSpan(ctx, "GetTenantByID", attribute.String("webhook.tenant_id", id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.GetTenantByID(ctx, id)
+}
+
+func (r *TracedWebhookRepo) GetWebhookByID(ctx context.Context, id int) (webhook *model.Webhook, err error) {
+	ctx, span := startRepoSpan(ctx, "GetWebhookByID", attribute.Int("webhook.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.GetWebhookByID(ctx, id)
+}
+
+func (r *TracedWebhookRepo) GetWebhookEventByID(ctx context.Context, id string) (event *model.WebhookEvent, err error) {
+	ctx, span := startRepoSpan(ctx, "GetWebhookEventByID", attribute.String("webhook.event.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.GetWebhookEventByID(ctx, id)
+}
+
+func (r *TracedWebhookRepo) UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) (err error) {
+	ctx, span := startRepoSpan(ctx, "UpdateWebhookEventById", attribute.String("webhook.event.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.UpdateWebhookEventById(ctx, id, event)
+}
+
+func (r *TracedWebhookRepo) UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) (err error) {
+	ctx, span := startRepoSpan(ctx, "UpdateWebhookEventPayload", attribute.String("webhook.event.id", event.Id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.UpdateWebhookEventPayload(ctx, event)
+}
+
+func (r *TracedWebhookRepo) ClaimWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string, until time.Time) (claimed bool, err error) {
+	ctx, span := startRepoSpan(ctx, "ClaimWebhookEvent", attribute.String("webhook.event.id", event.Id))
+	defer func() {
+		span.SetAttributes(attribute.Bool("webhook.event.claimed", claimed))
+		tracing.End(span, err)
+	}()
+
+	return r.WebhookRepositoryPort.ClaimWebhookEvent(ctx, event, owner, until)
+}
+
+func (r *TracedWebhookRepo) ReleaseWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string) (err error) {
+	ctx, span := startRepoSpan(ctx, "ReleaseWebhookEvent", attribute.String("webhook.event.id", event.Id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.ReleaseWebhookEvent(ctx, event, owner)
+}
+
+func (r *TracedWebhookRepo) ListStuckEvents(ctx context.Context, pendingBefore time.Time, leaseExpiredBefore time.Time, limit int) (events []model.WebhookEvent, err error) {
+	ctx, span := startRepoSpan(ctx, "ListStuckEvents")
+	defer func() {
+		span.SetAttributes(attribute.Int("db.response.returned_rows", len(events)))
+		tracing.End(span, err)
+	}()
+
+	return r.WebhookRepositoryPort.ListStuckEvents(ctx, pendingBefore, leaseExpiredBefore, limit)
+}
+
+func (r *TracedWebhookRepo) TouchWebhookEvent(ctx context.Context, event *model.WebhookEvent) (err error) {
+	ctx, span := startRepoSpan(ctx, "TouchWebhookEvent", attribute.String("webhook.event.id", event.Id))
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.TouchWebhookEvent(ctx, event)
+}
+
+func (r *TracedWebhookRepo) ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (page model.WebhookEventPage, err error) {
+	ctx, span := startRepoSpan(ctx, "ListWebhookEvents")
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.ListWebhookEvents(ctx, filter)
+}
+
+func (r *TracedWebhookRepo) TryLock(ctx context.Context, name string) (release func(), acquired bool, err error) {
+	ctx, span := startRepoSpan(ctx, "TryLock", attribute.String("webhook.lock.name", name))
+	defer func() {
+		span.SetAttributes(attribute.Bool("webhook.lock.acquired", acquired))
+		tracing.End(span, err)
+	}()
+
+	return r.WebhookRepositoryPort.TryLock(ctx, name)
+}
+
+// WithinTransaction spans the whole unit of work, the calls made by fn are
+// its children.
+func (r *TracedWebhookRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	ctx, span := startRepoSpan(ctx, "WithinTransaction")
+	defer func() { tracing.End(span, err) }()
+
+	return r.WebhookRepositoryPort.WithinTransaction(ctx, fn)
+}
+
+func startRepoSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "WebhookRepo."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.operation.name", method))...),
+	)
+}
@@ -2,6 +2,10 @@ package repo
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/webhook-processor/internal/webhook/domain/model"
 	"gorm.io/gorm"
@@ -9,68 +13,351 @@ import (
 
 type WebhookRepo struct {
 	db *gorm.DB
+	// locks backs TryLock on sqlite, a single process owns the database
+	mu    sync.Mutex
+	locks map[string]bool
 }
 
-type MyTransaction struct {
-	db *gorm.DB
-}
+// txKey carries the *gorm.DB of the current transaction in a context
+type txKey struct{}
 
-type Transaction interface {
-	Commit() error
-	Rollback() error
+func NewWebhookRepo(db *gorm.DB) *WebhookRepo {
+	return &WebhookRepo{db: db, locks: map[string]bool{}}
 }
 
-func NewWebhookRepo(db *gorm.DB) *WebhookRepo {
-	return &WebhookRepo{db: db}
+func (r *WebhookRepo) GetTenantByID(ctx context.Context, id string) (*model.Tenant, error) {
+	if !model.InTenantScope(ctx, id) {
+		return nil, nil
+	}
+
+	var tenant model.Tenant
+	if err := r.getDb(ctx).First(&tenant, "id = ?", id).Error; err != nil {
+		return nil, notFoundAsNil(err)
+	}
+	return &tenant, nil
 }
 
 func (r *WebhookRepo) GetWebhookByID(ctx context.Context, id int) (*model.Webhook, error) {
 	var webhook model.Webhook
-	if err := r.getDb(ctx).First(&webhook, "id = ?", id).Error; err != nil {
-		return nil, err
+	if err := r.scoped(ctx).First(&webhook, "id = ?", id).Error; err != nil {
+		return nil, notFoundAsNil(err)
 	}
 	return &webhook, nil
 }
 
 func (r *WebhookRepo) GetWebhookEventByID(ctx context.Context, id string) (*model.WebhookEvent, error) {
 	var event model.WebhookEvent
-	if err := r.getDb(ctx).First(&event, "id = ?", id).Error; err != nil {
-		return nil, err
+	if err := r.scoped(ctx).First(&event, "id = ?", id).Error; err != nil {
+		return nil, notFoundAsNil(err)
 	}
 	return &event, nil
 }
 
-func (r *WebhookRepo) UpdateWebhookEventById(ctx context.Context, id string, event model.WebhookEvent) error {
-	return r.getDb(ctx).Model(&model.WebhookEvent{}).Where("id = ?", id).Updates(event).Error
+// UpdateWebhookEventById uses a map instead of the struct so zero values
+// are written too, the immutable columns are never part of it.
+func (r *WebhookRepo) UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) error {
+	now := time.Now()
+	res := r.scoped(ctx).Model(&model.WebhookEvent{}).
+		Where("id = ? AND version = ?", id, event.Version).
+		Updates(map[string]interface{}{
+			"last_error":       event.LastError,
+			"response_body":    event.ResponseBody,
+			"response_code":    event.ResponseCode,
+			"tries":            event.Tries,
+			"status":           event.Status,
+			"lease_owner":      nullString(event.LeaseOwner),
+			"lease_expires_at": nullTime(event.LeaseExpiresAt),
+			"failed_at":        nullTime(event.FailedAt),
+			"delivered_at":     nullTime(event.DeliveredAt),
+			"updated_at":       now,
+			"version":          event.Version + 1,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &model.VersionConflictError{Id: id, Version: event.Version}
+	}
+
+	event.UpdatedAt = now
+	event.Version++
+	return nil
+}
+
+func (r *WebhookRepo) UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) error {
+	now := time.Now()
+	res := r.scoped(ctx).Model(&model.WebhookEvent{}).
+		Where("id = ? AND version = ?", event.Id, event.Version).
+		Updates(map[string]interface{}{
+			"payload":    event.Payload,
+			"updated_at": now,
+			"version":    event.Version + 1,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
+
+	event.UpdatedAt = now
+	event.Version++
+	return nil
+}
+
+func (r *WebhookRepo) ClaimWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string, until time.Time) (bool, error) {
+	now := time.Now()
+	res := r.scoped(ctx).Model(&model.WebhookEvent{}).
+		Where("id = ? AND version = ? AND (status = ? OR (status = ? AND lease_expires_at < ?))",
+			event.Id, event.Version, model.WebhookEventsStatusPending, model.WebhookEventsStatusInFlight, now).
+		Updates(map[string]interface{}{
+			"status":           model.WebhookEventsStatusInFlight,
+			"lease_owner":      owner,
+			"lease_expires_at": until,
+			"updated_at":       now,
+			"version":          event.Version + 1,
+		})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return false, res.Error
+	}
+
+	event.Status = model.WebhookEventsStatusInFlight
+	event.LeaseOwner = owner
+	event.LeaseExpiresAt = until
+	event.UpdatedAt = now
+	event.Version++
+	return true, nil
+}
+
+func (r *WebhookRepo) ReleaseWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string) error {
+	res := r.scoped(ctx).Model(&model.WebhookEvent{}).
+		Where("id = ? AND version = ? AND lease_owner = ? AND status <> ?", event.Id, event.Version, owner, model.WebhookEventsStatusInFlight).
+		Updates(map[string]interface{}{
+			"lease_owner":      nil,
+			"lease_expires_at": nil,
+			"version":          event.Version + 1,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
+
+	event.LeaseOwner = ""
+	event.LeaseExpiresAt = time.Time{}
+	event.Version++
+	return nil
+}
+
+func (r *WebhookRepo) ListStuckEvents(ctx context.Context, pendingBefore time.Time, leaseExpiredBefore time.Time, limit int) ([]model.WebhookEvent, error) {
+	var events []model.WebhookEvent
+	err := r.scoped(ctx).
+		Where("(status = ? AND updated_at < ?) OR (status = ? AND lease_expires_at < ?)",
+			model.WebhookEventsStatusPending, pendingBefore, model.WebhookEventsStatusInFlight, leaseExpiredBefore).
+		Order("updated_at").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r *WebhookRepo) ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error) {
+	query := r.scoped(ctx).Model(&model.WebhookEvent{})
+	if filter.WebhookId != 0 {
+		query = query.Where("webhook_id = ?", filter.WebhookId)
+	}
+	if filter.EventType != "" {
+		query = query.Where("event_type = ?", filter.EventType)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedTo)
+	}
+	if filter.ResponseCode != 0 {
+		query = query.Where("response_code = ?", filter.ResponseCode)
+	}
+	if len(filter.PayloadFields) > 0 && r.isSqlite() {
+		for path, value := range filter.PayloadFields {
+			query = query.Where("json_extract(payload, ?) = ?", "$."+path, value)
+		}
+	} else if len(filter.PayloadFields) > 0 {
+		contains, err := json.Marshal(filter.PayloadContains())
+		if err != nil {
+			return model.WebhookEventPage{}, err
+		}
+		query = query.Where("payload @> ?::jsonb", string(contains))
+	}
+	if filter.Cursor != nil {
+		query = query.Where("(created_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.Id)
+	}
+
+	limit := filter.PageLimit()
+	var events []model.WebhookEvent
+	// one extra row tells if there is a next page
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return model.WebhookEventPage{}, err
+	}
+
+	return newWebhookEventPage(events, limit), nil
 }
 
-func (r *WebhookRepo) Transaction(ctx *context.Context) MyTransaction {
-	trx := r.db.Begin()
-	*ctx = context.WithValue(*ctx, "trx", trx)
+func (r *WebhookRepo) TouchWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	now := time.Now()
+	res := r.scoped(ctx).Model(&model.WebhookEvent{}).
+		Where("id = ? AND version = ?", event.Id, event.Version).
+		Updates(map[string]interface{}{
+			"updated_at": now,
+			"version":    event.Version + 1,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &model.VersionConflictError{Id: event.Id, Version: event.Version}
+	}
 
-	return MyTransaction{db: trx}
+	event.UpdatedAt = now
+	event.Version++
+	return nil
 }
 
-func (trx MyTransaction) Commit(ctx *context.Context) error {
-	trx.resetTrx(ctx)
-	return trx.db.Commit().Error
+// TryLock uses a session level advisory lock, it lives on a dedicated
+// connection that is returned to the pool on release. On sqlite the lock is
+// local to the process.
+func (r *WebhookRepo) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	if r.isSqlite() {
+		return r.tryLocalLock(name)
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	acquired := false
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+		conn.Close()
+	}, true, nil
 }
 
-func (trx MyTransaction) Rollback(ctx *context.Context) error {
-	trx.resetTrx(ctx)
-	return trx.db.Rollback().Error
+func (r *WebhookRepo) tryLocalLock(name string) (func(), bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locks[name] {
+		return nil, false, nil
+	}
+	r.locks[name] = true
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.locks, name)
+	}, true, nil
 }
 
-func (trx MyTransaction) resetTrx(ctx *context.Context) {
-	*ctx = context.WithValue(*ctx, "trx", nil)
+// WithinTransaction runs fn in a transaction, repository calls made with
+// the ctx given to fn join it. Nested calls use savepoints, an error or a
+// panic rolls back only the innermost level.
+func (r *WebhookRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withinTransaction(ctx, r.db, fn)
 }
 
 func (r *WebhookRepo) getDb(ctx context.Context) *gorm.DB {
-	tx := ctx.Value("trx")
+	return dbFromContext(ctx, r.db)
+}
 
-	if tx == nil {
-		return r.db.WithContext(ctx)
+// withinTransaction runs fn in a transaction, the repos of this package
+// called with the context given to fn join it.
+func withinTransaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	return dbFromContext(ctx, db).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// dbFromContext returns the transaction ctx runs in, db outside of one.
+func dbFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
 	}
 
-	return tx.(*gorm.DB)
+	return db.WithContext(ctx)
+}
+
+// scoped restricts the query to the tenant ctx is scoped to, every webhook
+// and event query goes through it.
+func (r *WebhookRepo) scoped(ctx context.Context) *gorm.DB {
+	return tenantScoped(ctx, r.getDb(ctx))
+}
+
+// tenantScoped filters db on the tenant of ctx, it matches no row when ctx
+// has no scope and every row when it was scoped with model.AllTenants.
+func tenantScoped(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if model.IsAllTenants(ctx) {
+		return db
+	}
+	id, ok := model.TenantFromContext(ctx)
+	if !ok {
+		return db.Where("1 = 0")
+	}
+	return db.Where("tenant_id = ?", id)
+}
+
+func (r *WebhookRepo) isSqlite() bool {
+	return r.db.Dialector.Name() == "sqlite"
+}
+
+func newWebhookEventPage(events []model.WebhookEvent, limit int) model.WebhookEventPage {
+	if len(events) <= limit {
+		return model.WebhookEventPage{Events: events}
+	}
+
+	events = events[:limit]
+	return model.WebhookEventPage{
+		Events:     events,
+		NextCursor: model.CursorOf(&events[limit-1]).Encode(),
+	}
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// notFoundAsNil keeps a missing row apart from a database failure, callers
+// get (nil, nil) when there is nothing to return.
+func notFoundAsNil(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,284 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pgorm "github.com/webhook-processor/internal/shared/persistence/gorm"
+	"github.com/webhook-processor/internal/shared/persistence/migrations"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// repoFixture runs a test against a backend of the webhook repository,
+// save* put rows as they are, without tenant scoping.
+type repoFixture struct {
+	name        string
+	repo        ports.WebhookRepositoryPort
+	saveWebhook func(t *testing.T, webhook model.Webhook)
+	saveEvent   func(t *testing.T, event model.WebhookEvent)
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := pgorm.NewDB(pgorm.DbOptions{Driver: pgorm.DriverSqlite, SqlitePath: filepath.Join(t.TempDir(), "webhooks.db")})
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := migrations.NewMigrator(sqlDB, migrations.MigratorOpts{Dialect: migrations.DialectSqlite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func repoFixtures(t *testing.T) []repoFixture {
+	memory := NewMemoryWebhookRepo()
+	db := newTestDB(t)
+
+	return []repoFixture{
+		{
+			name:        "memory",
+			repo:        memory,
+			saveWebhook: func(t *testing.T, webhook model.Webhook) { memory.SaveWebhook(webhook) },
+			saveEvent:   func(t *testing.T, event model.WebhookEvent) { memory.SaveWebhookEvent(event) },
+		},
+		{
+			name: "sqlite",
+			repo: NewWebhookRepo(db),
+			saveWebhook: func(t *testing.T, webhook model.Webhook) {
+				if webhook.TenantId == "" {
+					webhook.TenantId = model.DEFAULT_TENANT_ID
+				}
+				if err := db.Create(&webhook).Error; err != nil {
+					t.Fatalf("save webhook: %v", err)
+				}
+			},
+			saveEvent: func(t *testing.T, event model.WebhookEvent) {
+				if event.TenantId == "" {
+					event.TenantId = model.DEFAULT_TENANT_ID
+				}
+				if err := db.Create(&event).Error; err != nil {
+					t.Fatalf("save event: %v", err)
+				}
+			},
+		},
+	}
+}
+
+func seedEvent(t *testing.T, f repoFixture, id string) {
+	t.Helper()
+	f.saveWebhook(t, model.Webhook{Id: 1, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive})
+	f.saveEvent(t, model.WebhookEvent{
+		Id:        id,
+		WebhookId: 1,
+		EventType: "order.created",
+		Payload:   datatypes.NewJSONType(model.Object{"order": "o-1"}),
+		Status:    model.WebhookEventsStatusPending,
+	})
+}
+
+func getEvent(t *testing.T, r ports.WebhookRepositoryPort, id string) *model.WebhookEvent {
+	t.Helper()
+	event, err := r.GetWebhookEventByID(model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID), id)
+	if err != nil || event == nil {
+		t.Fatalf("get event %s: %v %v", id, event, err)
+	}
+	return event
+}
+
+// TestReleaseKeepsLeaseInFlight releases an event whose final update
+// failed, it must keep its lease so the sweeper can reclaim it.
+func TestReleaseKeepsLeaseInFlight(t *testing.T) {
+	for _, f := range repoFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID)
+			seedEvent(t, f, "event-1")
+
+			event := getEvent(t, f.repo, "event-1")
+			until := time.Now().Add(time.Minute)
+			if claimed, err := f.repo.ClaimWebhookEvent(ctx, event, "consumer-1", until); err != nil || !claimed {
+				t.Fatalf("claim = %v, %v", claimed, err)
+			}
+
+			var conflict *model.VersionConflictError
+			if err := f.repo.ReleaseWebhookEvent(ctx, event, "consumer-1"); !errors.As(err, &conflict) {
+				t.Fatalf("release of an in flight event = %v, want a version conflict", err)
+			}
+			held := getEvent(t, f.repo, "event-1")
+			if !held.IsInFlight() || held.LeaseOwner != "consumer-1" || held.LeaseExpiresAt.IsZero() {
+				t.Fatalf("in flight event lost its lease: status=%s owner=%q expires=%s", held.Status, held.LeaseOwner, held.LeaseExpiresAt)
+			}
+			if !held.LeaseExpired(until.Add(time.Second)) {
+				t.Fatal("in flight event lease never expires")
+			}
+
+			held.MarkAsPending()
+			if err := f.repo.UpdateWebhookEventById(ctx, held.Id, held); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			if err := f.repo.ReleaseWebhookEvent(ctx, held, "consumer-1"); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			released := getEvent(t, f.repo, "event-1")
+			if released.LeaseOwner != "" || !released.LeaseExpiresAt.IsZero() {
+				t.Fatalf("lease kept after the event left in_flight: owner=%q expires=%s", released.LeaseOwner, released.LeaseExpiresAt)
+			}
+			if released.Version != held.Version {
+				t.Fatalf("stored version %d, released event has %d", released.Version, held.Version)
+			}
+		})
+	}
+}
+
+// TestStaleReleaseAndTouchConflict checks release and touch write only the
+// version they read, like every other update.
+func TestStaleReleaseAndTouchConflict(t *testing.T) {
+	for _, f := range repoFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID)
+			seedEvent(t, f, "event-1")
+
+			stale := getEvent(t, f.repo, "event-1")
+			fresh := getEvent(t, f.repo, "event-1")
+			if err := f.repo.TouchWebhookEvent(ctx, fresh); err != nil {
+				t.Fatalf("touch: %v", err)
+			}
+			if fresh.Version != stale.Version+1 {
+				t.Fatalf("touch left version %d, want %d", fresh.Version, stale.Version+1)
+			}
+
+			var conflict *model.VersionConflictError
+			if err := f.repo.TouchWebhookEvent(ctx, stale); !errors.As(err, &conflict) {
+				t.Fatalf("stale touch = %v, want a version conflict", err)
+			}
+
+			claimed := getEvent(t, f.repo, "event-1")
+			if ok, err := f.repo.ClaimWebhookEvent(ctx, claimed, "consumer-1", time.Now().Add(time.Minute)); err != nil || !ok {
+				t.Fatalf("claim = %v, %v", ok, err)
+			}
+			claimed.MarkAsPending()
+			if err := f.repo.UpdateWebhookEventById(ctx, claimed.Id, claimed); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+
+			staleRelease := *claimed
+			staleRelease.Version--
+			if err := f.repo.ReleaseWebhookEvent(ctx, &staleRelease, "consumer-1"); !errors.As(err, &conflict) {
+				t.Fatalf("stale release = %v, want a version conflict", err)
+			}
+			if err := f.repo.ReleaseWebhookEvent(ctx, claimed, "consumer-2"); !errors.As(err, &conflict) {
+				t.Fatalf("release by another owner = %v, want a version conflict", err)
+			}
+			if held := getEvent(t, f.repo, "event-1"); held.LeaseOwner != "consumer-1" {
+				t.Fatalf("lease owner %q after rejected releases", held.LeaseOwner)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	for _, f := range repoFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			for i, tenant := range []string{"tenant-a", "tenant-b"} {
+				f.saveWebhook(t, model.Webhook{Id: i + 1, TenantId: tenant, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive})
+				f.saveEvent(t, model.WebhookEvent{
+					Id:        "event-" + tenant,
+					TenantId:  tenant,
+					WebhookId: i + 1,
+					EventType: "order.created",
+					Payload:   datatypes.NewJSONType(model.Object{"order": "o-1"}),
+					Status:    model.WebhookEventsStatusPending,
+				})
+			}
+
+			tenantA := model.WithTenant(context.Background(), "tenant-a")
+			if event, err := f.repo.GetWebhookEventByID(tenantA, "event-tenant-b"); err != nil || event != nil {
+				t.Fatalf("tenant-a read the event of tenant-b: %v %v", event, err)
+			}
+			if webhook, err := f.repo.GetWebhookByID(tenantA, 2); err != nil || webhook != nil {
+				t.Fatalf("tenant-a read the webhook of tenant-b: %v %v", webhook, err)
+			}
+			page, err := f.repo.ListWebhookEvents(tenantA, model.WebhookEventFilter{Limit: 10})
+			if err != nil || len(page.Events) != 1 || page.Events[0].TenantId != "tenant-a" {
+				t.Fatalf("tenant-a listed %+v %v", page.Events, err)
+			}
+
+			unscoped := context.Background()
+			if event, err := f.repo.GetWebhookEventByID(unscoped, "event-tenant-a"); err != nil || event != nil {
+				t.Fatalf("a context without tenant read %v %v", event, err)
+			}
+			if webhook, err := f.repo.GetWebhookByID(unscoped, 1); err != nil || webhook != nil {
+				t.Fatalf("a context without tenant read %v %v", webhook, err)
+			}
+
+			all := model.AllTenants(context.Background())
+			for _, id := range []string{"event-tenant-a", "event-tenant-b"} {
+				if event, err := f.repo.GetWebhookEventByID(all, id); err != nil || event == nil {
+					t.Fatalf("all tenants read %s: %v %v", id, event, err)
+				}
+			}
+		})
+	}
+}
+
+// TestMemoryRollbackKeepsOtherWrites runs two workers, the rollback of the
+// first must only undo its own event.
+func TestMemoryRollbackKeepsOtherWrites(t *testing.T) {
+	memory := NewMemoryWebhookRepo()
+	f := repoFixture{
+		repo:        memory,
+		saveWebhook: func(t *testing.T, webhook model.Webhook) { memory.SaveWebhook(webhook) },
+		saveEvent:   func(t *testing.T, event model.WebhookEvent) { memory.SaveWebhookEvent(event) },
+	}
+	seedEvent(t, f, "event-1")
+	seedEvent(t, f, "event-2")
+	ctx := model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID)
+	until := time.Now().Add(time.Minute)
+
+	errWorker := errors.New("worker failed")
+	started := make(chan struct{})
+	second := make(chan error, 1)
+	err := memory.WithinTransaction(ctx, func(txCtx context.Context) error {
+		if ok, err := memory.ClaimWebhookEvent(txCtx, getEvent(t, memory, "event-1"), "consumer-1", until); err != nil || !ok {
+			t.Fatalf("claim event-1 = %v, %v", ok, err)
+		}
+
+		go func() {
+			close(started)
+			event, err := memory.GetWebhookEventByID(ctx, "event-2")
+			if err == nil {
+				_, err = memory.ClaimWebhookEvent(ctx, event, "consumer-2", until)
+			}
+			second <- err
+		}()
+		<-started
+		// gives the second worker the time to write while the transaction runs
+		time.Sleep(50 * time.Millisecond)
+		return errWorker
+	})
+	if !errors.Is(err, errWorker) {
+		t.Fatalf("transaction = %v, want the worker error", err)
+	}
+	if err := <-second; err != nil {
+		t.Fatalf("second worker: %v", err)
+	}
+
+	if event := getEvent(t, memory, "event-1"); !event.IsPending() || event.LeaseOwner != "" {
+		t.Errorf("event-1 after rollback = %s owned by %q, want pending", event.Status, event.LeaseOwner)
+	}
+	if event := getEvent(t, memory, "event-2"); !event.IsInFlight() || event.LeaseOwner != "consumer-2" {
+		t.Errorf("event-2 = %s owned by %q, want the claim of the second worker", event.Status, event.LeaseOwner)
+	}
+}
@@ -0,0 +1,267 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// SYSTEM_ACTOR is the actor of the entries recorded without one, e.g. by the
+// consumer
+const SYSTEM_ACTOR = "system"
+
+// AUDIT_REDACTED replaces the values of redacted fields in the changes
+const AUDIT_REDACTED = "[REDACTED]"
+
+// AUDIT_REDACTED_FIELDS are never written to the audit log, fields match as
+// a suffix of their dotted path (webhook.secret, settings.api_token)
+var AUDIT_REDACTED_FIELDS = []string{"secret", "password", "token", "token_hash", "authorization", "api_key"}
+
+const DEFAULT_AUDIT_PAGE_SIZE = 50
+const MAX_AUDIT_PAGE_SIZE = 200
+
+var ErrInvalidAuditFilter = errors.New("invalid audit filter")
+
+type AuditAction string
+
+const (
+	AuditActionTenantCreate      AuditAction = "tenant.create"
+	AuditActionTenantSettings    AuditAction = "tenant.settings.update"
+	AuditActionTenantStatus      AuditAction = "tenant.status.update"
+	AuditActionTenantTokenRotate AuditAction = "tenant.token.rotate"
+	// AuditActionEventReplay is an event published again outside of its
+	// retries, e.g. by the stuck event sweeper
+	AuditActionEventReplay     AuditAction = "webhook_event.replay"
+	AuditActionParkedRequeue   AuditAction = "parked_message.requeue"
+	AuditActionPartitionDrop   AuditAction = "partition.drop"
+	AuditActionSecretsEncrypt  AuditAction = "secrets.encrypt"
+	AuditActionDataKeysRekey   AuditAction = "data_keys.rekey"
+	AuditActionMasterKeyRotate AuditAction = "master_key.rotate"
+)
+
+const (
+	AuditTargetTenant        = "tenant"
+	AuditTargetWebhookEvent  = "webhook_event"
+	AuditTargetParkedMessage = "parked_message"
+	AuditTargetPartition     = "partition"
+	AuditTargetDataKeys      = "data_keys"
+	AuditTargetMasterKey     = "master_key"
+)
+
+// AuditTarget is what an action changed, TenantId is empty for targets
+// shared by every tenant.
+type AuditTarget struct {
+	Type     string
+	Id       string
+	TenantId string
+}
+
+// AuditChange is the value of one field before and after an action, null
+// on the side where the field does not exist.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntry records one action, entries are append only.
+type AuditEntry struct {
+	Id         int64       `json:"id"`
+	TenantId   string      `json:"tenant_id"`
+	Actor      string      `json:"actor"`
+	Action     AuditAction `json:"action"`
+	TargetType string      `json:"target_type"`
+	TargetId   string      `json:"target_id"`
+	// Changes is keyed by the dotted path of the changed fields
+	Changes   datatypes.JSONType[map[string]AuditChange] `json:"changes"`
+	Reason    string                                     `json:"reason,omitempty"`
+	CreatedAt time.Time                                  `json:"created_at"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// NewAuditEntry records action on target by the actor of ctx, before and
+// after are the target around the action (nil when it did not exist) and
+// are diffed field by field.
+func NewAuditEntry(ctx context.Context, action AuditAction, target AuditTarget, before interface{}, after interface{}) (*AuditEntry, error) {
+	changes, err := AuditDiff(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditEntry{
+		TenantId:   target.TenantId,
+		Actor:      ActorFromContext(ctx),
+		Action:     action,
+		TargetType: target.Type,
+		TargetId:   target.Id,
+		Changes:    datatypes.NewJSONType(changes),
+		Reason:     AuditReasonFromContext(ctx),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// AuditDiff compares the JSON encodings of before and after, nested objects
+// are compared field by field and arrays as a whole.
+func AuditDiff(before interface{}, after interface{}) (map[string]AuditChange, error) {
+	b, err := flattenJSON(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := flattenJSON(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+	for path, value := range b {
+		if other, ok := a[path]; !ok || !reflect.DeepEqual(value, other) {
+			changes[path] = AuditChange{Before: value, After: other}
+		}
+	}
+	for path, value := range a {
+		if _, ok := b[path]; !ok {
+			changes[path] = AuditChange{After: value}
+		}
+	}
+
+	for path, change := range changes {
+		if redactedField(path) {
+			changes[path] = AuditChange{Before: redacted(change.Before), After: redacted(change.After)}
+		}
+	}
+	return changes, nil
+}
+
+func flattenJSON(value interface{}) (map[string]interface{}, error) {
+	flat := map[string]interface{}{}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	// nil and nil pointers encode to null, the target did not exist
+	if decoded == nil {
+		return flat, nil
+	}
+	if _, ok := decoded.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("audit values must encode to JSON objects, got %T", value)
+	}
+
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			flat[prefix] = v
+			return
+		}
+		for key, child := range object {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, child)
+		}
+	}
+	walk("", decoded)
+	return flat, nil
+}
+
+func redactedField(path string) bool {
+	path = strings.ToLower(path)
+	for _, field := range AUDIT_REDACTED_FIELDS {
+		if path == field || strings.HasSuffix(path, "."+field) || strings.HasSuffix(path, "_"+field) {
+			return true
+		}
+	}
+	return false
+}
+
+func redacted(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return AUDIT_REDACTED
+}
+
+// actorKey and reasonKey carry who acts and why
+type actorKey struct{}
+type reasonKey struct{}
+
+// WithActor names who acts in ctx, e.g. operator:alice or system:sweeper.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SYSTEM_ACTOR
+}
+
+// WithAuditReason attaches why the actions of ctx are taken.
+func WithAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+func AuditReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
+
+// AuditFilter selects entries, zero values don't filter. Entries are listed
+// newest first.
+type AuditFilter struct {
+	Actor      string
+	Action     AuditAction
+	TargetType string
+	TargetId   string
+	From       time.Time
+	To         time.Time
+	// BeforeId continues a listing after the entry with that id
+	BeforeId int64
+	Limit    int
+}
+
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+func (f AuditFilter) Validate() error {
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidAuditFilter)
+	}
+	if f.TargetId != "" && f.TargetType == "" {
+		return fmt.Errorf("%w: target_id needs a target_type", ErrInvalidAuditFilter)
+	}
+	return nil
+}
+
+// PageLimit clamps Limit to the allowed page sizes.
+func (f AuditFilter) PageLimit() int {
+	if f.Limit <= 0 {
+		return DEFAULT_AUDIT_PAGE_SIZE
+	}
+	return min(f.Limit, MAX_AUDIT_PAGE_SIZE)
+}
+
+func DecodeAuditCursor(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"status":   "active",
+		"name":     "shop",
+		"settings": map[string]interface{}{"max_attempts": 5, "hosts": []string{"a"}},
+		"removed":  true,
+	}
+	after := map[string]interface{}{
+		"status":   "disabled",
+		"name":     "shop",
+		"settings": map[string]interface{}{"max_attempts": 5, "hosts": []string{"a", "b"}},
+		"added":    1,
+	}
+
+	changes, err := AuditDiff(before, after)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := map[string]AuditChange{
+		"status":         {Before: "active", After: "disabled"},
+		"settings.hosts": {Before: []interface{}{"a"}, After: []interface{}{"a", "b"}},
+		"removed":        {Before: true},
+		"added":          {After: float64(1)},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %#v, want %#v", changes, want)
+	}
+}
+
+func TestAuditDiffCreateAndInvalid(t *testing.T) {
+	changes, err := AuditDiff(nil, map[string]string{"id": "tenant-a"})
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if want := map[string]AuditChange{"id": {After: "tenant-a"}}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %#v, want %#v", changes, want)
+	}
+
+	if _, err := AuditDiff("not an object", nil); err == nil {
+		t.Errorf("diff of a string succeeded, want an error")
+	}
+}
+
+func TestAuditDiffRedaction(t *testing.T) {
+	before := map[string]interface{}{
+		"webhook":        map[string]string{"secret": "old", "url": "http://a"},
+		"api_token_hash": "h1",
+		"Authorization":  "Bearer x",
+		"tokens_issued":  1,
+	}
+	after := map[string]interface{}{
+		"webhook":        map[string]string{"secret": "new", "url": "http://b"},
+		"api_token_hash": "h2",
+		"tokens_issued":  2,
+		"password":       "p",
+	}
+
+	changes, err := AuditDiff(before, after)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := map[string]AuditChange{
+		"webhook.secret": {Before: AUDIT_REDACTED, After: AUDIT_REDACTED},
+		"webhook.url":    {Before: "http://a", After: "http://b"},
+		"api_token_hash": {Before: AUDIT_REDACTED, After: AUDIT_REDACTED},
+		"Authorization":  {Before: AUDIT_REDACTED},
+		// only whole field names are redacted
+		"tokens_issued": {Before: float64(1), After: float64(2)},
+		"password":      {After: AUDIT_REDACTED},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %#v, want %#v", changes, want)
+	}
+}
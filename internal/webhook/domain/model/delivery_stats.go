@@ -0,0 +1,296 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// DEFAULT_SLO_TARGET is the success rate objective of webhooks without one
+const DEFAULT_SLO_TARGET = 0.99
+
+// LATENCY_BUCKETS_MS are the upper bounds of the latency histogram of
+// delivery stats, an extra bucket counts slower attempts. Percentiles are
+// interpolated within a bucket so they stay mergeable across buckets.
+var LATENCY_BUCKETS_MS = []int{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+var ErrInvalidDeliveryStatsFilter = errors.New("invalid delivery stats filter")
+var ErrUnknownWebhook = errors.New("webhook not found")
+
+// DeliveryOutcome classifies one delivery attempt
+type DeliveryOutcome string
+
+const (
+	DeliveryOutcomeSuccess     DeliveryOutcome = "success"
+	DeliveryOutcomeClientError DeliveryOutcome = "4xx"
+	DeliveryOutcomeServerError DeliveryOutcome = "5xx"
+	DeliveryOutcomeTimeout     DeliveryOutcome = "timeout"
+	DeliveryOutcomeNetwork     DeliveryOutcome = "network"
+	// DeliveryOutcomeOther is a response outside 2xx, 4xx and 5xx
+	DeliveryOutcomeOther DeliveryOutcome = "other"
+)
+
+// DeliveryAttempt is one HTTP call made for an event, attempts are append
+// only and feed the delivery stats rollups.
+type DeliveryAttempt struct {
+	Id           int64           `json:"id"`
+	EventId      string          `json:"event_id"`
+	WebhookId    int             `json:"webhook_id"`
+	TenantId     string          `json:"tenant_id"`
+	Attempt      int             `json:"attempt"`
+	ResponseCode int             `json:"response_code"`
+	Outcome      DeliveryOutcome `json:"outcome"`
+	DurationMs   int             `json:"duration_ms"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// ClassifyDelivery gives the outcome of an attempt, code is 0 when no
+// response was received.
+func ClassifyDelivery(code int, success bool, timeout bool) DeliveryOutcome {
+	switch {
+	case success:
+		return DeliveryOutcomeSuccess
+	case timeout:
+		return DeliveryOutcomeTimeout
+	case code == 0:
+		return DeliveryOutcomeNetwork
+	case code >= 400 && code < 500:
+		return DeliveryOutcomeClientError
+	case code >= 500 && code < 600:
+		return DeliveryOutcomeServerError
+	default:
+		return DeliveryOutcomeOther
+	}
+}
+
+type StatsGranularity string
+
+const (
+	StatsGranularityMinute StatsGranularity = "minute"
+	StatsGranularityHour   StatsGranularity = "hour"
+	StatsGranularityDay    StatsGranularity = "day"
+)
+
+func (g StatsGranularity) Duration() time.Duration {
+	switch g {
+	case StatsGranularityMinute:
+		return time.Minute
+	case StatsGranularityHour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// Truncate returns the start of the UTC bucket holding t.
+func (g StatsGranularity) Truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(g.Duration())
+}
+
+func (g StatsGranularity) Valid() bool {
+	return g == StatsGranularityMinute || g == StatsGranularityHour || g == StatsGranularityDay
+}
+
+// WebhookDeliveryStats aggregates the attempts of a webhook over one
+// bucket, failures are counted by outcome.
+type WebhookDeliveryStats struct {
+	WebhookId       int              `json:"webhook_id" gorm:"primaryKey;autoIncrement:false"`
+	TenantId        string           `json:"tenant_id"`
+	Granularity     StatsGranularity `json:"granularity" gorm:"primaryKey"`
+	BucketStart     time.Time        `json:"bucket_start" gorm:"primaryKey"`
+	Attempts        int64            `json:"attempts"`
+	Successes       int64            `json:"successes"`
+	Failures4xx     int64            `json:"failures_4xx" gorm:"column:failures_4xx"`
+	Failures5xx     int64            `json:"failures_5xx" gorm:"column:failures_5xx"`
+	FailuresTimeout int64            `json:"failures_timeout"`
+	FailuresNetwork int64            `json:"failures_network"`
+	FailuresOther   int64            `json:"failures_other"`
+	LatencyP50Ms    int              `json:"latency_p50_ms" gorm:"column:latency_p50_ms"`
+	LatencyP95Ms    int              `json:"latency_p95_ms" gorm:"column:latency_p95_ms"`
+	LatencyP99Ms    int              `json:"latency_p99_ms" gorm:"column:latency_p99_ms"`
+	// LatencyHistogram counts attempts per LATENCY_BUCKETS_MS bucket
+	LatencyHistogram datatypes.JSONType[[]int64] `json:"-"`
+	UpdatedAt        time.Time                   `json:"updated_at"`
+}
+
+func NewWebhookDeliveryStats(webhookId int, tenantId string, granularity StatsGranularity, bucketStart time.Time) WebhookDeliveryStats {
+	return WebhookDeliveryStats{
+		WebhookId:        webhookId,
+		TenantId:         tenantId,
+		Granularity:      granularity,
+		BucketStart:      bucketStart,
+		LatencyHistogram: datatypes.NewJSONType(make([]int64, len(LATENCY_BUCKETS_MS)+1)),
+	}
+}
+
+func (s *WebhookDeliveryStats) Add(attempt DeliveryAttempt) {
+	s.Attempts++
+	switch attempt.Outcome {
+	case DeliveryOutcomeSuccess:
+		s.Successes++
+	case DeliveryOutcomeClientError:
+		s.Failures4xx++
+	case DeliveryOutcomeServerError:
+		s.Failures5xx++
+	case DeliveryOutcomeTimeout:
+		s.FailuresTimeout++
+	case DeliveryOutcomeNetwork:
+		s.FailuresNetwork++
+	default:
+		s.FailuresOther++
+	}
+
+	histogram := s.histogram()
+	histogram[latencyBucket(attempt.DurationMs)]++
+	s.LatencyHistogram = datatypes.NewJSONType(histogram)
+}
+
+// Merge adds the counts of a finer bucket, percentiles are recomputed by
+// Finalize.
+func (s *WebhookDeliveryStats) Merge(other WebhookDeliveryStats) {
+	s.Attempts += other.Attempts
+	s.Successes += other.Successes
+	s.Failures4xx += other.Failures4xx
+	s.Failures5xx += other.Failures5xx
+	s.FailuresTimeout += other.FailuresTimeout
+	s.FailuresNetwork += other.FailuresNetwork
+	s.FailuresOther += other.FailuresOther
+
+	histogram := s.histogram()
+	for i, count := range other.histogram() {
+		histogram[i] += count
+	}
+	s.LatencyHistogram = datatypes.NewJSONType(histogram)
+}
+
+// Finalize computes the latency percentiles from the histogram.
+func (s *WebhookDeliveryStats) Finalize() {
+	histogram := s.histogram()
+	s.LatencyP50Ms = percentile(histogram, 0.50)
+	s.LatencyP95Ms = percentile(histogram, 0.95)
+	s.LatencyP99Ms = percentile(histogram, 0.99)
+}
+
+func (s *WebhookDeliveryStats) Failures() int64 {
+	return s.Attempts - s.Successes
+}
+
+// histogram returns a copy sized for LATENCY_BUCKETS_MS, rows written with
+// fewer buckets are padded.
+func (s *WebhookDeliveryStats) histogram() []int64 {
+	histogram := make([]int64, len(LATENCY_BUCKETS_MS)+1)
+	copy(histogram, s.LatencyHistogram.Data())
+	return histogram
+}
+
+func latencyBucket(durationMs int) int {
+	for i, bound := range LATENCY_BUCKETS_MS {
+		if durationMs <= bound {
+			return i
+		}
+	}
+	return len(LATENCY_BUCKETS_MS)
+}
+
+// percentile interpolates linearly within the bucket holding the q-th
+// attempt, the overflow bucket reports the last bound.
+func percentile(histogram []int64, q float64) int {
+	var total int64
+	for _, count := range histogram {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen int64
+	for i, count := range histogram {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+		if i == len(LATENCY_BUCKETS_MS) {
+			break
+		}
+		lower := 0
+		if i > 0 {
+			lower = LATENCY_BUCKETS_MS[i-1]
+		}
+		upper := LATENCY_BUCKETS_MS[i]
+		return lower + int(float64(upper-lower)*(rank-float64(seen))/float64(count))
+	}
+	return LATENCY_BUCKETS_MS[len(LATENCY_BUCKETS_MS)-1]
+}
+
+// DeliveryStatsFilter selects the stats of one granularity over [From, To),
+// a zero WebhookId selects every webhook.
+type DeliveryStatsFilter struct {
+	WebhookId   int
+	Granularity StatsGranularity
+	From        time.Time
+	To          time.Time
+}
+
+// MAX_DELIVERY_STATS_BUCKETS bounds the buckets one query returns
+const MAX_DELIVERY_STATS_BUCKETS = 1500
+
+func (f DeliveryStatsFilter) Validate() error {
+	if !f.Granularity.Valid() {
+		return fmt.Errorf("%w: granularity must be minute, hour or day", ErrInvalidDeliveryStatsFilter)
+	}
+	if !f.From.Before(f.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidDeliveryStatsFilter)
+	}
+	if f.To.Sub(f.From) > time.Duration(MAX_DELIVERY_STATS_BUCKETS)*f.Granularity.Duration() {
+		return fmt.Errorf("%w: at most %d %s buckets", ErrInvalidDeliveryStatsFilter, MAX_DELIVERY_STATS_BUCKETS, f.Granularity)
+	}
+	return nil
+}
+
+// SLO_WINDOWS are the windows burn rates are reported over, the longest one
+// is the error budget period
+var SLO_WINDOWS = []time.Duration{time.Hour, 6 * time.Hour, 24 * time.Hour, 30 * 24 * time.Hour}
+
+type SLOWindow struct {
+	Window      string  `json:"window"`
+	Attempts    int64   `json:"attempts"`
+	Successes   int64   `json:"successes"`
+	SuccessRate float64 `json:"success_rate"`
+	// BurnRate is how fast the error budget is spent, 1 spends exactly the
+	// budget over the budget period
+	BurnRate float64 `json:"burn_rate"`
+}
+
+type SLOReport struct {
+	WebhookId int         `json:"webhook_id"`
+	Target    float64     `json:"target"`
+	Windows   []SLOWindow `json:"windows"`
+	// ErrorBudgetRemaining is the share of the budget of the last window
+	// left, negative once it is overspent
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+}
+
+func NewSLOWindow(window time.Duration, stats WebhookDeliveryStats, target float64) SLOWindow {
+	w := SLOWindow{Window: window.String(), Attempts: stats.Attempts, Successes: stats.Successes, SuccessRate: 1}
+	if window%(24*time.Hour) == 0 {
+		w.Window = fmt.Sprintf("%dd", window/(24*time.Hour))
+	} else if window%time.Hour == 0 {
+		w.Window = fmt.Sprintf("%dh", window/time.Hour)
+	}
+	if stats.Attempts > 0 {
+		w.SuccessRate = float64(stats.Successes) / float64(stats.Attempts)
+		w.BurnRate = (1 - w.SuccessRate) / (1 - target)
+	}
+	return w
+}
+
+// SLOTarget is the success rate objective of the webhook.
+func (w *Webhook) SLOTarget() float64 {
+	if w.SloTarget <= 0 || w.SloTarget >= 1 {
+		return DEFAULT_SLO_TARGET
+	}
+	return w.SloTarget
+}
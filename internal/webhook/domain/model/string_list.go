@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// StringList is a list column portable across databases: a text[] on
+// Postgres and a JSON array anywhere else.
+type StringList []string
+
+func (l StringList) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "text[]"
+	}
+	return "text"
+}
+
+func (l StringList) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {
+	if db.Dialector.Name() == "postgres" {
+		return clause.Expr{SQL: "?", Vars: []interface{}{l.postgresArray()}}
+	}
+
+	value, _ := l.Value()
+	return clause.Expr{SQL: "?", Vars: []interface{}{value}}
+}
+
+// Value encodes a JSON array, Postgres goes through GormValue.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal([]string(l))
+	return string(encoded), err
+}
+
+// Scan reads both a JSON array and a Postgres array literal.
+func (l *StringList) Scan(src interface{}) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+
+	if strings.HasPrefix(raw, "{") {
+		list, err := parsePostgresArray(raw)
+		if err != nil {
+			return err
+		}
+		*l = list
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return fmt.Errorf("cannot scan %q into StringList: %w", raw, err)
+	}
+	*l = list
+	return nil
+}
+
+func (l StringList) postgresArray() string {
+	quoted := make([]string, len(l))
+	for i, s := range l {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// parsePostgresArray reads a one dimensional text[] literal such as
+// {a,"b c",NULL}, NULL elements are dropped.
+func parsePostgresArray(raw string) (StringList, error) {
+	if !strings.HasPrefix(raw, "{") || !strings.HasSuffix(raw, "}") {
+		return nil, fmt.Errorf("invalid array literal %q", raw)
+	}
+
+	list := StringList{}
+	body := raw[1 : len(raw)-1]
+	if body == "" {
+		return list, nil
+	}
+
+	var current strings.Builder
+	quoted, inQuotes, escaped := false, false, false
+	flush := func() {
+		value := current.String()
+		if quoted || value != "NULL" {
+			list = append(list, value)
+		}
+		current.Reset()
+		quoted = false
+	}
+
+	for _, r := range body {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+		case r == ',' && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes || escaped {
+		return nil, fmt.Errorf("invalid array literal %q", raw)
+	}
+	flush()
+
+	return list, nil
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// DEFAULT_TENANT_ID owns the webhooks created before tenants existed
+const DEFAULT_TENANT_ID = "default"
+
+var ErrInvalidTenantSettings = errors.New("invalid tenant settings")
+
+type TenantStatus string
+
+const (
+	TenantStatusActive   TenantStatus = "active"
+	TenantStatusDisabled TenantStatus = "disabled"
+)
+
+type SigningMode string
+
+const (
+	// SigningModeHMAC sends x-signature: sha256=<hmac of the body>
+	SigningModeHMAC SigningMode = "hmac_sha256"
+	// SigningModeTimestampedHMAC signs "<unix seconds>.<body>" and sends the
+	// timestamp in x-signature-timestamp so receivers can reject replays
+	SigningModeTimestampedHMAC SigningMode = "hmac_sha256_timestamped"
+	SigningModeNone            SigningMode = "none"
+)
+
+// Tenant (an application) owns webhooks and their events, nothing is
+// shared between tenants.
+type Tenant struct {
+	Id       string                             `json:"id"`
+	Name     string                             `json:"name"`
+	Status   TenantStatus                       `json:"status"`
+	Settings datatypes.JSONType[TenantSettings] `json:"settings"`
+	// APITokenHash is the sha256 of the token the tenant uses on the API
+	APITokenHash *string   `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TenantSettings are the per tenant defaults, zero values fall back to the
+// processor defaults.
+type TenantSettings struct {
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// MaxRetryDelayMs can only lower MAX_RETRY_DELAY, the sweeper expects
+	// retries to happen within it
+	MaxRetryDelayMs int `json:"max_retry_delay_ms,omitempty"`
+	// RateLimitPerSecond caps the deliveries of the tenant on each consumer,
+	// 0 means unlimited
+	RateLimitPerSecond float64     `json:"rate_limit_per_second,omitempty"`
+	RateLimitBurst     int         `json:"rate_limit_burst,omitempty"`
+	SigningMode        SigningMode `json:"signing_mode,omitempty"`
+}
+
+func (t *Tenant) IsActive() bool {
+	return t.Status == TenantStatusActive
+}
+
+func (s TenantSettings) Validate() error {
+	if s.MaxAttempts < 0 || s.MaxRetryDelayMs < 0 || s.RateLimitPerSecond < 0 || s.RateLimitBurst < 0 {
+		return fmt.Errorf("%w: values can't be negative", ErrInvalidTenantSettings)
+	}
+	switch s.SigningMode {
+	case "", SigningModeHMAC, SigningModeTimestampedHMAC, SigningModeNone:
+	default:
+		return fmt.Errorf("%w: unknown signing mode %q", ErrInvalidTenantSettings, s.SigningMode)
+	}
+	return nil
+}
+
+func (s TenantSettings) RetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy
+	if s.MaxAttempts > 0 {
+		policy.MaxAttempts = s.MaxAttempts
+	}
+	if s.MaxRetryDelayMs > 0 {
+		policy.MaxDelay = min(time.Duration(s.MaxRetryDelayMs)*time.Millisecond, MAX_RETRY_DELAY)
+	}
+	return policy
+}
+
+// RateLimitBurstOrDefault allows one second worth of deliveries at once
+// when no burst is configured.
+func (s TenantSettings) RateLimitBurstOrDefault() int {
+	if s.RateLimitBurst > 0 {
+		return s.RateLimitBurst
+	}
+	return max(int(math.Ceil(s.RateLimitPerSecond)), 1)
+}
+
+func (s TenantSettings) Signing() SigningMode {
+	if s.SigningMode == "" {
+		return SigningModeHMAC
+	}
+	return s.SigningMode
+}
+
+type RetryPolicy struct {
+	MaxAttempts int
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: MAX_WEBHOOK_SEND_ATTEMPTS,
+	MaxDelay:    MAX_RETRY_DELAY,
+}
+
+// Backoff is the delay before the attempt that follows the given number of
+// tries, jitter is added by whoever schedules it.
+func (p RetryPolicy) Backoff(tries int) time.Duration {
+	exp := math.Pow(2, float64(tries))
+	return time.Duration(math.Min(exp*1000, float64(p.MaxDelay.Milliseconds()))) * time.Millisecond
+}
+
+// tenantKey carries the id of the tenant a context is scoped to
+type tenantKey struct{}
+
+// allTenants is the scope set by AllTenants
+type allTenants struct{}
+
+// WithTenant scopes ctx to a tenant, repositories only return and update
+// the rows of that tenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// AllTenants scopes ctx to every tenant, it is the explicit opt-in of the
+// jobs working across tenants (the consumer before it knows the tenant of
+// a message, the sweeper, maintenance and admin commands).
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, allTenants{})
+}
+
+// TenantFromContext returns the tenant ctx is scoped to, it is false for
+// a context scoped to every tenant or to none.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok
+}
+
+// IsAllTenants tells if ctx was scoped to every tenant with AllTenants.
+func IsAllTenants(ctx context.Context) bool {
+	_, ok := ctx.Value(tenantKey{}).(allTenants)
+	return ok
+}
+
+// InTenantScope tells if the rows of tenant id are visible from ctx, a
+// context without a scope sees nothing.
+func InTenantScope(ctx context.Context, id string) bool {
+	if IsAllTenants(ctx) {
+		return true
+	}
+	scope, ok := TenantFromContext(ctx)
+	return ok && scope == id
+}
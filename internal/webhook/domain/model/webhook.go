@@ -1,15 +1,19 @@
 package model
 
-import (
-	"time"
-
-	"github.com/lib/pq"
-)
+import "time"
 
 const WEBHOOK_QUEUE = "webhook_queue"
+const WEBHOOK_PARKING_QUEUE = "webhook_parking"
 const EXCHANGE_NAME = "webhook_exchange"
 const ROUTING_KEY = "webhook.process"
+const WEBHOOK_STREAM = "WEBHOOKS"
 const MAX_WEBHOOK_SEND_ATTEMPTS = 5
+const DEFAULT_WEBHOOK_TIMEOUT = 5 * time.Second
+const MAX_WEBHOOK_TIMEOUT = 30 * time.Second
+
+// WEBHOOK_LEASE_MARGIN is added to the delivery timeout so a lease outlives
+// the HTTP call and the writes that follow it
+const WEBHOOK_LEASE_MARGIN = 30 * time.Second
 
 type WebhookStatus string
 
@@ -19,17 +23,35 @@ const (
 )
 
 type Webhook struct {
-	Id               int            `json:"id"`
-	FailureCount     int            `json:"failure_count"`
-	CallbackURL      string         `json:"callback_url"`
-	Secret           string         `json:"secret"`
-	Status           WebhookStatus  `json:"status"`
-	LastFailureAt    time.Time      `json:"last_failure_at"`
-	CreatedAt        time.Time      `json:"created_at"`
-	UpdatedAt        time.Time      `json:"updated_at"`
-	SubscribedEvents pq.StringArray `json:"subscribed_events" gorm:"type:text[]"`
+	Id               int           `json:"id"`
+	TenantId         string        `json:"tenant_id"`
+	FailureCount     int           `json:"failure_count"`
+	CallbackURL      string        `json:"callback_url"`
+	Secret           string        `json:"secret"`
+	Status           WebhookStatus `json:"status"`
+	TimeoutMs        int           `json:"timeout_ms"`
+	LastFailureAt    time.Time     `json:"last_failure_at"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+	SubscribedEvents StringList    `json:"subscribed_events"`
+	// SloTarget is the success rate objective, e.g. 0.995, 0 uses
+	// DEFAULT_SLO_TARGET
+	SloTarget float64 `json:"slo_target"`
 }
 
 func (w *Webhook) IsActive() bool {
 	return w.Status == WebhookStatusActive
 }
+
+// DeliveryTimeout is the deadline applied to a single delivery attempt,
+// falling back to the default when the webhook has none configured.
+func (w *Webhook) DeliveryTimeout() time.Duration {
+	if w.TimeoutMs <= 0 {
+		return DEFAULT_WEBHOOK_TIMEOUT
+	}
+	return min(time.Duration(w.TimeoutMs)*time.Millisecond, MAX_WEBHOOK_TIMEOUT)
+}
+
+func (w *Webhook) LeaseDuration() time.Duration {
+	return w.DeliveryTimeout() + WEBHOOK_LEASE_MARGIN
+}
@@ -1,16 +1,51 @@
 package model
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrRateLimited = errors.New("tenant rate limit exceeded")
+
+// codes of the errors that end a delivery attempt, other codes mean the
+// delivery was skipped
+const (
+	CODE_WILL_RETRY                   = "will_retry"
+	CODE_FAILED                       = "failed"
+	CODE_PAYLOAD_SERIALIZATION_FAILED = "payload_serialization_failed"
+)
 
 type WebhookError struct {
 	error
 	Retryable bool
+	// Transient errors come from our own infrastructure (e.g. the database
+	// is down), the event was not processed and the message must be kept
+	Transient bool
+	// RetryAfter is the delay before the retry when the service decides it,
+	// zero leaves it to the caller
+	RetryAfter time.Duration
+	// Code names the kind of error, e.g. for metric labels
+	Code string
 }
 
 func (e *WebhookError) IsRetryable() bool {
 	return e.Retryable
 }
 
+func (e *WebhookError) IsTransient() bool {
+	return e.Transient
+}
+
+func (e *WebhookError) Unwrap() error {
+	return e.error
+}
+
+func (e *WebhookError) withCode(code string) *WebhookError {
+	e.Code = code
+	return e
+}
+
 func New(err error, retryable bool) *WebhookError {
 	return &WebhookError{
 		error:     err,
@@ -18,39 +53,97 @@ func New(err error, retryable bool) *WebhookError {
 	}
 }
 
+func NewTransient(err error) *WebhookError {
+	return &WebhookError{
+		error:     err,
+		Retryable: true,
+		Transient: true,
+	}
+}
+
+// VersionConflictError is returned by repositories when an update was based
+// on a stale version of the event
+type VersionConflictError struct {
+	Id      string
+	Version int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("webhook event %s was modified concurrently, version %d is stale", e.Id, e.Version)
+}
+
 func newError(message string, args ...interface{}) error {
-	return fmt.Errorf(message, args)
+	return errors.New(message + argsSuffix(args...))
+}
+
+func argsSuffix(args ...interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return ": " + fmt.Sprint(args...)
 }
 
 var (
 	// webhook
 	ErrWebhookNotFound = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook not found", args...), false)
+		return New(newError("webhook not found", args...), false).withCode("webhook_not_found")
 	}
 	ErrWebhookIsDisabled = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook is disabled", args...), false)
+		return New(newError("webhook is disabled", args...), false).withCode("webhook_disabled")
+	}
+
+	// tenant
+	ErrTenantNotFound = func(args ...interface{}) *WebhookError {
+		return New(newError("tenant not found", args...), false).withCode("tenant_not_found")
+	}
+	ErrTenantIsDisabled = func(args ...interface{}) *WebhookError {
+		return New(newError("tenant is disabled", args...), false).withCode("tenant_disabled")
+	}
+	// ErrTenantRateLimited does not count as an attempt, the message is
+	// published again after retryAfter
+	ErrTenantRateLimited = func(retryAfter time.Duration, args ...interface{}) *WebhookError {
+		err := New(fmt.Errorf("%w%s", ErrRateLimited, argsSuffix(args...)), true).withCode("rate_limited")
+		err.RetryAfter = retryAfter
+		return err
 	}
 
 	// webhook event
 	ErrWebhookEventNotPending = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event is not pending", args...), false)
+		return New(newError("webhook event is not pending", args...), false).withCode("not_pending")
+	}
+	ErrWebhookEventAlreadyInFlight = func(args ...interface{}) *WebhookError {
+		return New(newError("webhook event is already being delivered", args...), false).withCode("in_flight")
 	}
 	ErrWebhookEventReachedMaxAttempts = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event reached max attempts", args...), false)
+		return New(newError("webhook event reached max attempts", args...), false).withCode("max_attempts")
 	}
 	ErrWebhookEventPayloadSerializationFailed = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event payload serialization failed", args...), false)
+		return New(newError("webhook event payload serialization failed", args...), false).withCode(CODE_PAYLOAD_SERIALIZATION_FAILED)
 	}
 	ErrWebhookEventDeliveryFailed = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event delivery failed", args...), false)
+		return New(newError("webhook event delivery failed", args...), false).withCode("delivery_failed")
 	}
 	ErrWebhookEventNotFound = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event not found", args...), false)
+		return New(newError("webhook event not found", args...), false).withCode("event_not_found")
 	}
 	ErrWebhookEventFails = func(args ...interface{}) *WebhookError {
-		return New(newError("webhook event fails and marked as failed", args...), false)
+		return New(newError("webhook event fails and marked as failed", args...), false).withCode(CODE_FAILED)
+	}
+	ErrWebhookEventUpdateConflict = func(args ...interface{}) *WebhookError {
+		return New(newError("webhook event was updated by another delivery", args...), false).withCode("update_conflict")
+	}
+	ErrWebhookEventDeliveryCanceled = func(args ...interface{}) *WebhookError {
+		return New(newError("webhook event delivery canceled", args...), false).withCode("canceled")
+	}
+	ErrWebhookEventInfrastructureUnavailable = func(args ...interface{}) *WebhookError {
+		return NewTransient(newError("webhook event infrastructure unavailable", args...)).withCode("infrastructure_unavailable")
 	}
 	ErrWebhookEventWillRetry = func(args ...interface{}) *WebhookError {
-		return New(newError("we will try again to process the event code=%d", args...), true)
+		return New(newError(fmt.Sprintf("we will try again to process the event code=%d", args...)), true).withCode(CODE_WILL_RETRY)
+	}
+	ErrWebhookEventWillRetryAfter = func(retryAfter time.Duration, code int) *WebhookError {
+		err := ErrWebhookEventWillRetry(code)
+		err.RetryAfter = retryAfter
+		return err
 	}
 )
@@ -12,6 +12,7 @@ type WebhookEventsStatus string
 
 const (
 	WebhookEventsStatusPending    WebhookEventsStatus = "pending"
+	WebhookEventsStatusInFlight   WebhookEventsStatus = "in_flight"
 	WebhookEventsStatusDelivered  WebhookEventsStatus = "delivered"
 	WebhookEventsStatusFailed     WebhookEventsStatus = "failed"
 	WebhookEventsStatusDeadLetter WebhookEventsStatus = "dead_letter"
@@ -19,32 +20,52 @@ const (
 
 type Object = map[string]interface{}
 
-type WebhookEventMessage struct {
-	Id string `json:"id"`
-}
-
 type WebhookEvent struct {
-	Id           string                     `json:"id"`
-	WebhookId    int                        `json:"webhook_id"`
-	EventType    string                     `json:"event_type"`
-	Payload      datatypes.JSONType[Object] `json:"payload"`
-	LastError    datatypes.JSONType[Object] `json:"last_error"`
-	ResponseBody datatypes.JSONType[Object] `json:"response_body"`
-	ResponseCode int                        `json:"response_code"`
-	Tries        int                        `json:"tries"`
-	Status       WebhookEventsStatus        `json:"status"`
-	FailedAt     time.Time                  `json:"failed_at,omitempty"`
-	DeliveredAt  time.Time                  `json:"delivered_at,omitempty"`
-	CreatedAt    time.Time                  `json:"created_at"`
-	UpdatedAt    time.Time                  `json:"updated_at"`
+	Id             string                     `json:"id"`
+	TenantId       string                     `json:"tenant_id"`
+	WebhookId      int                        `json:"webhook_id"`
+	EventType      string                     `json:"event_type"`
+	Payload        datatypes.JSONType[Object] `json:"payload"`
+	LastError      datatypes.JSONType[Object] `json:"last_error"`
+	ResponseBody   datatypes.JSONType[Object] `json:"response_body"`
+	ResponseCode   int                        `json:"response_code"`
+	Tries          int                        `json:"tries"`
+	Status         WebhookEventsStatus        `json:"status"`
+	LeaseOwner     string                     `json:"lease_owner,omitempty"`
+	LeaseExpiresAt time.Time                  `json:"lease_expires_at,omitempty"`
+	FailedAt       time.Time                  `json:"failed_at,omitempty"`
+	DeliveredAt    time.Time                  `json:"delivered_at,omitempty"`
+	Version        int                        `json:"version"`
+	CreatedAt      time.Time                  `json:"created_at"`
+	UpdatedAt      time.Time                  `json:"updated_at"`
 }
 
 func (wb *WebhookEvent) IsPending() bool {
 	return wb.Status == WebhookEventsStatusPending
 }
 
-func (wb *WebhookEvent) ReachedMaxAttempts() bool {
-	return wb.Tries >= MAX_WEBHOOK_SEND_ATTEMPTS
+func (wb *WebhookEvent) IsInFlight() bool {
+	return wb.Status == WebhookEventsStatusInFlight
+}
+
+func (wb *WebhookEvent) LeaseExpired(now time.Time) bool {
+	return wb.IsInFlight() && wb.LeaseExpiresAt.Before(now)
+}
+
+// IsDeliverable tells if a delivery can claim the event, an in_flight event
+// whose lease expired was abandoned and can be claimed again.
+func (wb *WebhookEvent) IsDeliverable(now time.Time) bool {
+	return wb.IsPending() || wb.LeaseExpired(now)
+}
+
+func (wb *WebhookEvent) ReachedMaxAttempts(policy RetryPolicy) bool {
+	return wb.Tries >= policy.MaxAttempts
+}
+
+// NextAttemptAt is the latest time the retry of a pending event is
+// expected to run, the backoff plus its maximum jitter.
+func (wb *WebhookEvent) NextAttemptAt() time.Time {
+	return wb.UpdatedAt.Add(RetryBackoff(wb.Tries) * 3 / 2)
 }
 
 func (wb *WebhookEvent) CheckSuccessResponse(code int) bool {
@@ -69,12 +90,28 @@ func (wb *WebhookEvent) MarkAsDelivered() {
 	wb.DeliveredAt = time.Now()
 }
 
+func (wb *WebhookEvent) MarkAsPending() {
+	wb.Status = WebhookEventsStatusPending
+}
+
 func (wb *WebhookEvent) MarkAsFailed(error map[string]interface{}) {
 	wb.LastError = datatypes.NewJSONType(error)
 	wb.Status = WebhookEventsStatusFailed
 	wb.FailedAt = time.Now()
 }
 
+func (wb *WebhookEvent) SetLastError(error Object) {
+	wb.LastError = datatypes.NewJSONType(error)
+}
+
 func (wb *WebhookEvent) SetResponseBody(responseBody Object) {
 	wb.ResponseBody = datatypes.NewJSONType(responseBody)
 }
+
+const MAX_RETRY_DELAY = 60 * time.Second
+
+// RetryBackoff is the delay of the default retry policy before the attempt
+// that follows the given number of tries.
+func RetryBackoff(tries int) time.Duration {
+	return DefaultRetryPolicy.Backoff(tries)
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DEFAULT_WEBHOOK_EVENTS_PAGE_SIZE = 50
+const MAX_WEBHOOK_EVENTS_PAGE_SIZE = 200
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidWebhookEventFilter = errors.New("invalid webhook event filter")
+
+// WebhookEventFilter selects events, zero values don't filter. Events are
+// listed newest first, ordered by (created_at, id).
+type WebhookEventFilter struct {
+	WebhookId    int
+	EventType    string
+	Statuses     []WebhookEventsStatus
+	CreatedFrom  time.Time
+	CreatedTo    time.Time
+	ResponseCode int
+	// PayloadFields matches payload values by dotted path, e.g.
+	// {"customer.id": 42}
+	PayloadFields map[string]interface{}
+	Cursor        *WebhookEventCursor
+	Limit         int
+}
+
+// WebhookEventCursor points at the last event of a page, the next page
+// starts right after it.
+type WebhookEventCursor struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+type WebhookEventPage struct {
+	Events     []WebhookEvent `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func CursorOf(event *WebhookEvent) *WebhookEventCursor {
+	return &WebhookEventCursor{CreatedAt: event.CreatedAt, Id: event.Id}
+}
+
+// Before tells if event comes after the cursor in listing order.
+func (c *WebhookEventCursor) Before(event *WebhookEvent) bool {
+	if event.CreatedAt.Equal(c.CreatedAt) {
+		return event.Id < c.Id
+	}
+	return event.CreatedAt.Before(c.CreatedAt)
+}
+
+func (c *WebhookEventCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeWebhookEventCursor(value string) (*WebhookEventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+
+	return &WebhookEventCursor{CreatedAt: t, Id: id}, nil
+}
+
+func (f WebhookEventFilter) Validate() error {
+	if !f.CreatedFrom.IsZero() && !f.CreatedTo.IsZero() && !f.CreatedFrom.Before(f.CreatedTo) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidWebhookEventFilter)
+	}
+	for path := range f.PayloadFields {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return fmt.Errorf("%w: invalid payload path %q", ErrInvalidWebhookEventFilter, path)
+		}
+	}
+	return nil
+}
+
+// PageLimit clamps Limit to the allowed page sizes.
+func (f WebhookEventFilter) PageLimit() int {
+	if f.Limit <= 0 {
+		return DEFAULT_WEBHOOK_EVENTS_PAGE_SIZE
+	}
+	return min(f.Limit, MAX_WEBHOOK_EVENTS_PAGE_SIZE)
+}
+
+// Matches applies the filter in memory, payload values are compared by
+// their printed form so 42 matches both 42 and "42".
+func (f WebhookEventFilter) Matches(event *WebhookEvent) bool {
+	if f.WebhookId != 0 && event.WebhookId != f.WebhookId {
+		return false
+	}
+	if f.EventType != "" && event.EventType != f.EventType {
+		return false
+	}
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, event.Status) {
+		return false
+	}
+	if !f.CreatedFrom.IsZero() && event.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && !event.CreatedAt.Before(f.CreatedTo) {
+		return false
+	}
+	if f.ResponseCode != 0 && event.ResponseCode != f.ResponseCode {
+		return false
+	}
+	if f.Cursor != nil && !f.Cursor.Before(event) {
+		return false
+	}
+	return f.MatchesPayload(event.Payload.Data())
+}
+
+// MatchesPayload follows the JSONB @> operator used by the postgres repo:
+// payload must contain PayloadContains, values compare with their JSON type
+// (42 does not match "42") and arrays contain every expected element.
+func (f WebhookEventFilter) MatchesPayload(payload Object) bool {
+	if len(f.PayloadFields) == 0 {
+		return true
+	}
+
+	have, err := normalizeJSON(payload)
+	if err != nil {
+		return false
+	}
+	want, err := normalizeJSON(f.PayloadContains())
+	if err != nil {
+		return false
+	}
+	return jsonContains(have, want)
+}
+
+// normalizeJSON gives values the types encoding/json decodes to, so numbers
+// compare as float64 whatever they were built with.
+func normalizeJSON(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	return normalized, json.Unmarshal(raw, &normalized)
+}
+
+func jsonContains(have interface{}, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		object, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			child, ok := object[key]
+			if !ok || !jsonContains(child, value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		array, ok := have.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range want {
+			if !slices.ContainsFunc(array, func(element interface{}) bool { return jsonContains(element, value) }) {
+				return false
+			}
+		}
+		return true
+	default:
+		return have == want
+	}
+}
+
+// PayloadContains builds the nested object matched with the JSONB @>
+// operator.
+func (f WebhookEventFilter) PayloadContains() Object {
+	contains := Object{}
+	for path, expected := range f.PayloadFields {
+		keys := strings.Split(path, ".")
+		object := contains
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := object[key].(Object)
+			if !ok {
+				next = Object{}
+				object[key] = next
+			}
+			object = next
+		}
+		object[keys[len(keys)-1]] = expected
+	}
+	return contains
+}
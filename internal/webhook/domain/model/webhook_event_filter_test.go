@@ -0,0 +1,40 @@
+package model
+
+import "testing"
+
+func TestMatchesPayloadContainment(t *testing.T) {
+	payload := Object{
+		"customer": map[string]interface{}{"id": 42, "tier": "gold"},
+		"tags":     []interface{}{"a", "b"},
+		"paid":     true,
+		"ref":      "42",
+	}
+
+	for _, tc := range []struct {
+		name   string
+		fields map[string]interface{}
+		want   bool
+	}{
+		{"no filter", nil, true},
+		{"nested number", map[string]interface{}{"customer.id": 42.0}, true},
+		{"nested number as int", map[string]interface{}{"customer.id": 42}, true},
+		{"two fields of one object", map[string]interface{}{"customer.id": 42.0, "customer.tier": "gold"}, true},
+		{"string does not match number", map[string]interface{}{"customer.id": "42"}, false},
+		{"number does not match string", map[string]interface{}{"ref": 42.0}, false},
+		{"bool", map[string]interface{}{"paid": true}, true},
+		{"bool as string", map[string]interface{}{"paid": "true"}, false},
+		{"missing key", map[string]interface{}{"customer.name": "x"}, false},
+		{"path through a scalar", map[string]interface{}{"ref.id": "x"}, false},
+		{"array element", map[string]interface{}{"tags": []interface{}{"a"}}, true},
+		{"array element missing", map[string]interface{}{"tags": []interface{}{"c"}}, false},
+		{"scalar against array", map[string]interface{}{"tags": "a"}, false},
+		{"null is not missing", map[string]interface{}{"missing": nil}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := WebhookEventFilter{PayloadFields: tc.fields}
+			if got := filter.MatchesPayload(payload); got != tc.want {
+				t.Fatalf("MatchesPayload(%v) = %v, want %v", tc.fields, got, tc.want)
+			}
+		})
+	}
+}
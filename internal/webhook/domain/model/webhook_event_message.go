@@ -0,0 +1,70 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WEBHOOK_EVENT_MESSAGE_VERSION is the envelope schema version written by
+// this code, version 1 is the legacy bare {"id": ...} message.
+const WEBHOOK_EVENT_MESSAGE_VERSION = 2
+const WEBHOOK_EVENT_MESSAGE_TYPE = "webhook.event"
+
+var ErrInvalidWebhookEventMessage = errors.New("invalid webhook event message")
+
+type WebhookEventMessage struct {
+	Version     int       `json:"v"`
+	Id          string    `json:"id"`
+	WebhookId   int       `json:"webhook_id,omitempty"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	Attempt     int       `json:"attempt,omitempty"`
+	TraceParent string    `json:"traceparent,omitempty"`
+	TraceState  string    `json:"tracestate,omitempty"`
+	TenantId    string    `json:"tenant_id,omitempty"`
+	Producer    string    `json:"producer,omitempty"`
+}
+
+func NewWebhookEventMessage(event *WebhookEvent, producer string) WebhookEventMessage {
+	return WebhookEventMessage{
+		Version:    WEBHOOK_EVENT_MESSAGE_VERSION,
+		Id:         event.Id,
+		WebhookId:  event.WebhookId,
+		EnqueuedAt: time.Now().UTC(),
+		Attempt:    event.Tries + 1,
+		TenantId:   event.TenantId,
+		Producer:   producer,
+	}
+}
+
+// NextAttempt is the message to publish when the delivery is retried, it
+// keeps the original enqueue time and trace context.
+func (m WebhookEventMessage) NextAttempt() WebhookEventMessage {
+	m.Version = WEBHOOK_EVENT_MESSAGE_VERSION
+	m.Attempt++
+	return m
+}
+
+func (m WebhookEventMessage) Encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func DecodeWebhookEventMessage(body []byte) (WebhookEventMessage, error) {
+	msg := WebhookEventMessage{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, fmt.Errorf("%w: %s", ErrInvalidWebhookEventMessage, err)
+	}
+
+	if msg.Version == 0 {
+		msg.Version = 1
+	}
+	if msg.Version > WEBHOOK_EVENT_MESSAGE_VERSION {
+		return msg, fmt.Errorf("%w: unsupported version %d", ErrInvalidWebhookEventMessage, msg.Version)
+	}
+	if msg.Id == "" {
+		return msg, fmt.Errorf("%w: missing id", ErrInvalidWebhookEventMessage)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+
+	env "github.com/webhook-processor/internal/shared/env"
+	log "github.com/webhook-processor/internal/shared/logger"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+type auditService struct {
+	repo ports.AuditRepositoryPort
+}
+
+func NewAuditService(repo ports.AuditRepositoryPort) *auditService {
+	return &auditService{repo: repo}
+}
+
+func (s *auditService) Record(ctx context.Context, action model.AuditAction, target model.AuditTarget, before interface{}, after interface{}) error {
+	entry, err := model.NewAuditEntry(ctx, action, target, before, after)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SaveAuditEntry(context.WithoutCancel(ctx), entry); err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "audit", "actor", entry.Actor, "action", entry.Action, "target_type", entry.TargetType, "target_id", entry.TargetId)
+	return nil
+}
+
+func (s *auditService) ListAuditEntries(ctx context.Context, filter model.AuditFilter) (model.AuditPage, error) {
+	if err := filter.Validate(); err != nil {
+		return model.AuditPage{}, err
+	}
+	return s.repo.ListAuditEntries(ctx, filter)
+}
+
+// OperatorContextFromEnv names the operator running a command line tool
+// from AUDIT_ACTOR (defaults to operator:$USER) and the reason from
+// AUDIT_REASON.
+func OperatorContextFromEnv(ctx context.Context) context.Context {
+	actor := env.GetEnvOrDefault("AUDIT_ACTOR", "")
+	if actor == "" {
+		actor = "operator:" + env.GetEnvOrDefault("USER", "unknown")
+	}
+	ctx = model.WithActor(ctx, actor)
+	if reason := env.GetEnvOrDefault("AUDIT_REASON", ""); reason != "" {
+		ctx = model.WithAuditReason(ctx, reason)
+	}
+	return ctx
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+// SLO_MINUTE_WINDOW is the longest SLO window summed from minute buckets,
+// longer ones are summed from hour buckets
+const SLO_MINUTE_WINDOW = 6 * time.Hour
+
+type deliveryStatsQueryService struct {
+	repo  ports.WebhookRepositoryPort
+	stats ports.DeliveryStatsRepositoryPort
+}
+
+func NewDeliveryStatsQueryService(repo ports.WebhookRepositoryPort, stats ports.DeliveryStatsRepositoryPort) *deliveryStatsQueryService {
+	return &deliveryStatsQueryService{repo: repo, stats: stats}
+}
+
+func (s *deliveryStatsQueryService) ListDeliveryStats(ctx context.Context, filter model.DeliveryStatsFilter) ([]model.WebhookDeliveryStats, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	// stats of every webhook are only filtered by the tenant scope
+	if filter.WebhookId != 0 {
+		if _, err := s.getWebhook(ctx, filter.WebhookId); err != nil {
+			return nil, err
+		}
+	}
+	return s.stats.ListDeliveryStats(ctx, filter)
+}
+
+// GetSLOReport sums the buckets of every SLO window up to now, windows are
+// aligned on the granularity they are summed from.
+func (s *deliveryStatsQueryService) GetSLOReport(ctx context.Context, webhookId int) (*model.SLOReport, error) {
+	wb, err := s.getWebhook(ctx, webhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	// one query per granularity covers all the windows summed from it
+	longest := map[model.StatsGranularity]time.Duration{}
+	for _, window := range model.SLO_WINDOWS {
+		granularity := sloGranularity(window)
+		longest[granularity] = max(longest[granularity], window)
+	}
+
+	now := time.Now()
+	rows := map[model.StatsGranularity][]model.WebhookDeliveryStats{}
+	for granularity, window := range longest {
+		rows[granularity], err = s.stats.ListDeliveryStats(ctx, model.DeliveryStatsFilter{
+			WebhookId:   webhookId,
+			Granularity: granularity,
+			From:        granularity.Truncate(now.Add(-window)),
+			To:          now,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &model.SLOReport{WebhookId: webhookId, Target: wb.SLOTarget(), ErrorBudgetRemaining: 1}
+	for _, window := range model.SLO_WINDOWS {
+		granularity := sloGranularity(window)
+		from := granularity.Truncate(now.Add(-window))
+
+		total := model.NewWebhookDeliveryStats(webhookId, wb.TenantId, granularity, from)
+		for _, row := range rows[granularity] {
+			if !row.BucketStart.Before(from) {
+				total.Merge(row)
+			}
+		}
+		report.Windows = append(report.Windows, model.NewSLOWindow(window, total, report.Target))
+	}
+	if n := len(report.Windows); n > 0 {
+		report.ErrorBudgetRemaining = 1 - report.Windows[n-1].BurnRate
+	}
+
+	return report, nil
+}
+
+func (s *deliveryStatsQueryService) getWebhook(ctx context.Context, id int) (*model.Webhook, error) {
+	wb, err := s.repo.GetWebhookByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if wb == nil {
+		return nil, model.ErrUnknownWebhook
+	}
+	return wb, nil
+}
+
+func sloGranularity(window time.Duration) model.StatsGranularity {
+	if window <= SLO_MINUTE_WINDOW {
+		return model.StatsGranularityMinute
+	}
+	return model.StatsGranularityHour
+}
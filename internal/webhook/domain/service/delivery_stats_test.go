@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/adapters/repo"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+func statsRow(webhookId int, granularity model.StatsGranularity, start time.Time, attempts int64, successes int64) model.WebhookDeliveryStats {
+	row := model.NewWebhookDeliveryStats(webhookId, model.DEFAULT_TENANT_ID, granularity, start)
+	row.Attempts = attempts
+	row.Successes = successes
+	return row
+}
+
+func TestListDeliveryStatsEveryWebhook(t *testing.T) {
+	memory := repo.NewMemoryWebhookRepo()
+	memory.SaveWebhook(model.Webhook{Id: 1, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive})
+	stats := newMemoryStats()
+	start := time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)
+	stats.UpsertDeliveryStats(context.Background(), []model.WebhookDeliveryStats{
+		statsRow(1, model.StatsGranularityHour, start, 10, 10),
+		statsRow(2, model.StatsGranularityHour, start, 10, 5),
+	})
+
+	ctx := model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID)
+	svc := NewDeliveryStatsQueryService(memory, stats)
+	filter := model.DeliveryStatsFilter{Granularity: model.StatsGranularityHour, From: start, To: start.Add(time.Hour)}
+
+	rows, err := svc.ListDeliveryStats(ctx, filter)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("every webhook = %d rows %v, want 2", len(rows), err)
+	}
+
+	filter.WebhookId = 99
+	if _, err := svc.ListDeliveryStats(ctx, filter); !errors.Is(err, model.ErrUnknownWebhook) {
+		t.Fatalf("unknown webhook = %v, want ErrUnknownWebhook", err)
+	}
+}
+
+func TestSLOReportBurnRate(t *testing.T) {
+	memory := repo.NewMemoryWebhookRepo()
+	memory.SaveWebhook(model.Webhook{Id: 1, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive, SloTarget: 0.9})
+	stats := newMemoryStats()
+	now := time.Now()
+	stats.UpsertDeliveryStats(context.Background(), []model.WebhookDeliveryStats{
+		statsRow(1, model.StatsGranularityMinute, model.StatsGranularityMinute.Truncate(now.Add(-10*time.Minute)), 10, 9),
+		statsRow(1, model.StatsGranularityHour, model.StatsGranularityHour.Truncate(now.Add(-48*time.Hour)), 100, 75),
+		// older than the longest window
+		statsRow(1, model.StatsGranularityHour, model.StatsGranularityHour.Truncate(now.Add(-40*24*time.Hour)), 100, 0),
+	})
+
+	report, err := NewDeliveryStatsQueryService(memory, stats).GetSLOReport(model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID), 1)
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	want := map[string]float64{"1h": 1, "6h": 1, "24h": 0, "30d": 2.5}
+	for _, window := range report.Windows {
+		if math.Abs(window.BurnRate-want[window.Window]) > 1e-9 {
+			t.Errorf("%s burn rate = %v, want %v", window.Window, window.BurnRate, want[window.Window])
+		}
+	}
+	if math.Abs(report.ErrorBudgetRemaining-(-1.5)) > 1e-9 {
+		t.Errorf("error budget remaining = %v, want -1.5", report.ErrorBudgetRemaining)
+	}
+}
@@ -1,18 +1,37 @@
 package service
 
 import (
+	"os"
+
+	"github.com/google/uuid"
 	"github.com/webhook-processor/internal/shared/http"
 	"github.com/webhook-processor/internal/webhook/ports"
 )
 
 type webhookService struct {
-	repo       ports.WebhookRepositoryPort
+	repo ports.WebhookRepositoryPort
+	// stats records every attempt, nil disables the delivery stats
+	stats      ports.DeliveryStatsRepositoryPort
 	httpClient *http.HTTPClient
+	// leaseOwner identifies this process on the events it claims
+	leaseOwner string
+	limiters   *tenantLimiters
 }
 
-func NewWebhookService(repo ports.WebhookRepositoryPort, httpClient *http.HTTPClient) *webhookService {
+func NewWebhookService(repo ports.WebhookRepositoryPort, stats ports.DeliveryStatsRepositoryPort, httpClient *http.HTTPClient) *webhookService {
 	return &webhookService{
 		repo:       repo,
+		stats:      stats,
 		httpClient: httpClient,
+		leaseOwner: newLeaseOwner(),
+		limiters:   newTenantLimiters(),
+	}
+}
+
+func newLeaseOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
 	}
+	return hostname + "/" + uuid.NewString()
 }
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+type webhookQueryService struct {
+	repo ports.WebhookRepositoryPort
+}
+
+func NewWebhookQueryService(repo ports.WebhookRepositoryPort) *webhookQueryService {
+	return &webhookQueryService{repo: repo}
+}
+
+func (s *webhookQueryService) ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error) {
+	if err := filter.Validate(); err != nil {
+		return model.WebhookEventPage{}, err
+	}
+	return s.repo.ListWebhookEvents(ctx, filter)
+}
+
+func (s *webhookQueryService) GetWebhookEvent(ctx context.Context, id string) (*model.WebhookEvent, error) {
+	return s.repo.GetWebhookEventByID(ctx, id)
+}
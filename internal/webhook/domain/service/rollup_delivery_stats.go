@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+const DELIVERY_STATS_ROLLUP_LOCK = "webhook-processor:delivery-stats-rollup"
+
+// DeliveryStatsRollup aggregates the delivery attempts into minute buckets,
+// minute buckets into hour buckets and hour buckets into day buckets. Every
+// pass recomputes the buckets touched by the lookback so late attempts are
+// counted and reruns are idempotent.
+type DeliveryStatsRollup struct {
+	repo  ports.WebhookRepositoryPort
+	stats ports.DeliveryStatsRepositoryPort
+	opts  DeliveryStatsRollupOpts
+}
+
+type DeliveryStatsRollupOpts struct {
+	Interval time.Duration
+	// Lookback is how far back each pass recomputes the minute buckets, it
+	// must cover the attempts still being written
+	Lookback time.Duration
+	// Retention keeps the buckets of a granularity, a granularity missing
+	// from the map is kept forever
+	Retention map[model.StatsGranularity]time.Duration
+}
+
+// DEFAULT_DELIVERY_STATS_RETENTION keeps minute buckets a week and hour
+// buckets 90 days, day buckets are kept forever
+var DEFAULT_DELIVERY_STATS_RETENTION = map[model.StatsGranularity]time.Duration{
+	model.StatsGranularityMinute: 7 * 24 * time.Hour,
+	model.StatsGranularityHour:   90 * 24 * time.Hour,
+}
+
+func NewDeliveryStatsRollup(repo ports.WebhookRepositoryPort, stats ports.DeliveryStatsRepositoryPort, opts DeliveryStatsRollupOpts) *DeliveryStatsRollup {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Lookback <= 0 {
+		opts.Lookback = 5 * time.Minute
+	}
+	if opts.Retention == nil {
+		opts.Retention = DEFAULT_DELIVERY_STATS_RETENTION
+	}
+	// hour and day buckets are rebuilt from the finer buckets of their
+	// whole span, those must still be there
+	retention := maps.Clone(opts.Retention)
+	if r, ok := retention[model.StatsGranularityMinute]; ok && r < 2*time.Hour {
+		retention[model.StatsGranularityMinute] = 2 * time.Hour
+	}
+	if r, ok := retention[model.StatsGranularityHour]; ok && r < 2*24*time.Hour {
+		retention[model.StatsGranularityHour] = 2 * 24 * time.Hour
+	}
+	opts.Retention = retention
+
+	return &DeliveryStatsRollup{repo: repo, stats: stats, opts: opts}
+}
+
+func (r *DeliveryStatsRollup) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.RollupOnce(ctx, time.Now()); err != nil {
+				log.ErrorContext(ctx, "delivery stats rollup failed", "err", err)
+			}
+		}
+	}
+}
+
+// RollupOnce rolls the lookback up to now and prunes the expired buckets,
+// only the replica holding the lock rolls up.
+func (r *DeliveryStatsRollup) RollupOnce(ctx context.Context, now time.Time) (int, error) {
+	release, acquired, err := r.repo.TryLock(ctx, DELIVERY_STATS_ROLLUP_LOCK)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		log.DebugContext(ctx, "delivery stats rollup running on another replica")
+		return 0, nil
+	}
+	defer release()
+
+	buckets, err := r.Rollup(ctx, now.Add(-r.opts.Lookback), now)
+	if err != nil {
+		return buckets, err
+	}
+	return buckets, r.prune(ctx, now)
+}
+
+// Rollup recomputes every bucket holding attempts made in [from, to) and
+// returns how many were written. It does not lock, backfills call it
+// directly.
+func (r *DeliveryStatsRollup) Rollup(ctx context.Context, from time.Time, to time.Time) (int, error) {
+	ctx = model.AllTenants(ctx)
+	from = model.StatsGranularityMinute.Truncate(from)
+	written := 0
+
+	// attempts are read an hour at a time to bound memory on backfills
+	for start := from; start.Before(to); start = start.Add(time.Hour) {
+		end := start.Add(time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		attempts, err := r.stats.ListDeliveryAttempts(ctx, start, end)
+		if err != nil {
+			return written, err
+		}
+
+		buckets := map[statsKey]*model.WebhookDeliveryStats{}
+		for _, attempt := range attempts {
+			bucket(buckets, model.StatsGranularityMinute, attempt.WebhookId, attempt.TenantId, attempt.CreatedAt).Add(attempt)
+		}
+		n, err := r.save(ctx, buckets)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	for _, g := range [][2]model.StatsGranularity{
+		{model.StatsGranularityMinute, model.StatsGranularityHour},
+		{model.StatsGranularityHour, model.StatsGranularityDay},
+	} {
+		n, err := r.merge(ctx, g[0], g[1], from, to)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	log.InfoContext(ctx, "delivery stats rollup done", "from", from, "to", to, "buckets", written)
+	return written, nil
+}
+
+// merge rebuilds the coarse buckets overlapping [from, to) from the fine
+// buckets they span.
+func (r *DeliveryStatsRollup) merge(ctx context.Context, fine model.StatsGranularity, coarse model.StatsGranularity, from time.Time, to time.Time) (int, error) {
+	rows, err := r.stats.ListDeliveryStats(ctx, model.DeliveryStatsFilter{
+		Granularity: fine,
+		From:        coarse.Truncate(from),
+		To:          coarse.Truncate(to.Add(-time.Nanosecond)).Add(coarse.Duration()),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	buckets := map[statsKey]*model.WebhookDeliveryStats{}
+	for _, row := range rows {
+		bucket(buckets, coarse, row.WebhookId, row.TenantId, row.BucketStart).Merge(row)
+	}
+	return r.save(ctx, buckets)
+}
+
+func (r *DeliveryStatsRollup) save(ctx context.Context, buckets map[statsKey]*model.WebhookDeliveryStats) (int, error) {
+	rows := make([]model.WebhookDeliveryStats, 0, len(buckets))
+	for _, b := range buckets {
+		b.Finalize()
+		rows = append(rows, *b)
+	}
+	return len(rows), r.stats.UpsertDeliveryStats(ctx, rows)
+}
+
+func (r *DeliveryStatsRollup) prune(ctx context.Context, now time.Time) error {
+	ctx = model.AllTenants(ctx)
+	for granularity, retention := range r.opts.Retention {
+		deleted, err := r.stats.DeleteDeliveryStats(ctx, granularity, now.Add(-retention))
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.InfoContext(ctx, "delivery stats pruned", "granularity", granularity, "deleted", deleted)
+		}
+	}
+	return nil
+}
+
+type statsKey struct {
+	webhookId int
+	start     time.Time
+}
+
+func bucket(buckets map[statsKey]*model.WebhookDeliveryStats, granularity model.StatsGranularity, webhookId int, tenantId string, at time.Time) *model.WebhookDeliveryStats {
+	key := statsKey{webhookId: webhookId, start: granularity.Truncate(at)}
+	b, ok := buckets[key]
+	if !ok {
+		stats := model.NewWebhookDeliveryStats(webhookId, tenantId, granularity, key.start)
+		b = &stats
+		buckets[key] = b
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/adapters/repo"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+type statsRowKey struct {
+	webhookId   int
+	granularity model.StatsGranularity
+	start       time.Time
+}
+
+// memoryStats keeps the attempts and buckets in maps, tenants are ignored.
+type memoryStats struct {
+	attempts []model.DeliveryAttempt
+	rows     map[statsRowKey]model.WebhookDeliveryStats
+}
+
+func newMemoryStats() *memoryStats {
+	return &memoryStats{rows: map[statsRowKey]model.WebhookDeliveryStats{}}
+}
+
+func (m *memoryStats) SaveDeliveryAttempt(ctx context.Context, attempt *model.DeliveryAttempt) error {
+	m.attempts = append(m.attempts, *attempt)
+	return nil
+}
+
+func (m *memoryStats) ListDeliveryAttempts(ctx context.Context, from time.Time, to time.Time) ([]model.DeliveryAttempt, error) {
+	var attempts []model.DeliveryAttempt
+	for _, attempt := range m.attempts {
+		if !attempt.CreatedAt.Before(from) && attempt.CreatedAt.Before(to) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *memoryStats) ListDeliveryStats(ctx context.Context, filter model.DeliveryStatsFilter) ([]model.WebhookDeliveryStats, error) {
+	var rows []model.WebhookDeliveryStats
+	for key, row := range m.rows {
+		if key.granularity == filter.Granularity && !key.start.Before(filter.From) && key.start.Before(filter.To) &&
+			(filter.WebhookId == 0 || key.webhookId == filter.WebhookId) {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func (m *memoryStats) UpsertDeliveryStats(ctx context.Context, stats []model.WebhookDeliveryStats) error {
+	for _, row := range stats {
+		m.rows[statsRowKey{row.WebhookId, row.Granularity, row.BucketStart}] = row
+	}
+	return nil
+}
+
+func (m *memoryStats) DeleteDeliveryStats(ctx context.Context, granularity model.StatsGranularity, before time.Time) (int64, error) {
+	var deleted int64
+	for key := range m.rows {
+		if key.granularity == granularity && key.start.Before(before) {
+			delete(m.rows, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memoryStats) row(t *testing.T, granularity model.StatsGranularity, start time.Time) model.WebhookDeliveryStats {
+	t.Helper()
+	row, ok := m.rows[statsRowKey{1, granularity, start}]
+	if !ok {
+		t.Fatalf("no %s bucket at %s", granularity, start)
+	}
+	return row
+}
+
+func attemptAt(at time.Time, outcome model.DeliveryOutcome) model.DeliveryAttempt {
+	return model.DeliveryAttempt{WebhookId: 1, TenantId: model.DEFAULT_TENANT_ID, Outcome: outcome, DurationMs: 40, CreatedAt: at}
+}
+
+func TestRollupBucketBoundaries(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	stats := newMemoryStats()
+	stats.attempts = []model.DeliveryAttempt{
+		attemptAt(day.Add(11*time.Hour-time.Millisecond), model.DeliveryOutcomeSuccess),
+		attemptAt(day.Add(11*time.Hour), model.DeliveryOutcomeServerError),
+		attemptAt(day.Add(11*time.Hour+30*time.Second), model.DeliveryOutcomeSuccess),
+		attemptAt(day.Add(24*time.Hour-time.Second), model.DeliveryOutcomeTimeout),
+		attemptAt(day.Add(24*time.Hour), model.DeliveryOutcomeSuccess),
+		// to is excluded
+		attemptAt(day.Add(24*time.Hour+time.Minute), model.DeliveryOutcomeSuccess),
+	}
+
+	rollup := NewDeliveryStatsRollup(repo.NewMemoryWebhookRepo(), stats, DeliveryStatsRollupOpts{})
+	if _, err := rollup.Rollup(context.Background(), day.Add(10*time.Hour+59*time.Minute+30*time.Second), day.Add(24*time.Hour+time.Minute)); err != nil {
+		t.Fatalf("rollup: %v", err)
+	}
+
+	tests := []struct {
+		granularity model.StatsGranularity
+		start       time.Time
+		attempts    int64
+		successes   int64
+	}{
+		{model.StatsGranularityMinute, day.Add(10*time.Hour + 59*time.Minute), 1, 1},
+		{model.StatsGranularityMinute, day.Add(11 * time.Hour), 2, 1},
+		{model.StatsGranularityMinute, day.Add(24*time.Hour - time.Minute), 1, 0},
+		{model.StatsGranularityMinute, day.Add(24 * time.Hour), 1, 1},
+		{model.StatsGranularityHour, day.Add(10 * time.Hour), 1, 1},
+		{model.StatsGranularityHour, day.Add(11 * time.Hour), 2, 1},
+		{model.StatsGranularityHour, day.Add(23 * time.Hour), 1, 0},
+		{model.StatsGranularityHour, day.Add(24 * time.Hour), 1, 1},
+		{model.StatsGranularityDay, day, 4, 2},
+		{model.StatsGranularityDay, day.Add(24 * time.Hour), 1, 1},
+	}
+	for _, tt := range tests {
+		row := stats.row(t, tt.granularity, tt.start)
+		if row.Attempts != tt.attempts || row.Successes != tt.successes {
+			t.Errorf("%s bucket %s = %d attempts %d successes, want %d %d", tt.granularity, tt.start, row.Attempts, row.Successes, tt.attempts, tt.successes)
+		}
+	}
+	if _, ok := stats.rows[statsRowKey{1, model.StatsGranularityMinute, day.Add(24*time.Hour + time.Minute)}]; ok {
+		t.Errorf("the attempt made at to was rolled up")
+	}
+}
+
+// TestRollupMergesWholeHour checks a pass whose lookback covers the end of
+// an hour rebuilds the hour from every minute of it, and reruns are
+// idempotent.
+func TestRollupMergesWholeHour(t *testing.T) {
+	hour := time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)
+	stats := newMemoryStats()
+	rollup := NewDeliveryStatsRollup(repo.NewMemoryWebhookRepo(), stats, DeliveryStatsRollupOpts{})
+	ctx := context.Background()
+
+	stats.attempts = append(stats.attempts, attemptAt(hour.Add(time.Minute), model.DeliveryOutcomeSuccess))
+	if _, err := rollup.Rollup(ctx, hour, hour.Add(5*time.Minute)); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+
+	stats.attempts = append(stats.attempts, attemptAt(hour.Add(58*time.Minute), model.DeliveryOutcomeClientError))
+	for pass := 0; pass < 2; pass++ {
+		if _, err := rollup.Rollup(ctx, hour.Add(55*time.Minute), hour.Add(time.Hour)); err != nil {
+			t.Fatalf("late pass: %v", err)
+		}
+		for _, granularity := range []model.StatsGranularity{model.StatsGranularityHour, model.StatsGranularityDay} {
+			row := stats.row(t, granularity, granularity.Truncate(hour))
+			if row.Attempts != 2 || row.Successes != 1 || row.Failures4xx != 1 {
+				t.Errorf("pass %d %s bucket = %+v, want both attempts", pass, granularity, row)
+			}
+		}
+	}
+}
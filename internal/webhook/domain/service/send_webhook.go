@@ -10,18 +10,60 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"time"
 
 	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/shared/metrics"
 
 	"github.com/webhook-processor/internal/shared/http"
 	"github.com/webhook-processor/internal/webhook/domain/model"
 )
 
 func (s *webhookService) SendWebhook(ctx context.Context, msg model.WebhookEventMessage) (event *model.WebhookEvent, errWb *model.WebhookError) {
-	event, wb, errWb := s.getAndValidatePreconditions(ctx, msg)
-	if errWb != nil {
+	defer func() { recordOutcome(errWb) }()
+
+	// a message naming a tenant can only deliver the events of that tenant,
+	// the messages published before tenants existed are scoped once the
+	// event is read
+	if msg.TenantId != "" {
+		ctx = model.WithTenant(ctx, msg.TenantId)
+	} else {
+		ctx = model.AllTenants(ctx)
+	}
+
+	// read, validate and claim as one unit so the claim is never taken on
+	// a state we did not check
+	var wb *model.Webhook
+	var tenant *model.Tenant
+	err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		var errWb *model.WebhookError
+		event, wb, tenant, errWb = s.getAndValidatePreconditions(ctx, msg)
+		if errWb == nil {
+			errWb = s.throttle(ctx, tenant)
+		}
+		if errWb == nil {
+			errWb = s.claim(ctx, event, wb)
+		}
+		if errWb != nil {
+			return errWb
+		}
+		return nil
+	})
+	if errors.As(err, &errWb) {
 		return event, errWb
 	}
+	if err != nil {
+		log.ErrorContext(ctx, "transaction error", "err", err)
+		return event, model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
+	}
+	// once the final update moved the event out of in_flight this clears
+	// the lease, when that update failed the event keeps its lease and the
+	// sweeper picks it up after it expires
+	defer s.release(ctx, event)
+
+	settings := tenant.Settings.Data()
+	policy := settings.RetryPolicy()
 
 	jsonBytes, err := json.Marshal(event.Payload)
 	if err != nil {
@@ -29,32 +71,48 @@ func (s *webhookService) SendWebhook(ctx context.Context, msg model.WebhookEvent
 	}
 
 	reader := bytes.NewReader(jsonBytes)
-	signature, err := s.generateHMACSignature(event.Payload.Data(), wb.Secret)
+	headers, err := s.signatureHeaders(event.Payload.Data(), wb.Secret, settings.Signing(), time.Now())
 	if err != nil {
 		return s.markAsDeadLetter(ctx, event, err)
 	}
 
-	res, err := s.httpClient.Post(wb.CallbackURL, "application/json", reader, map[string]string{
-		"x-signature": signature,
-	})
+	deliveryCtx, cancel := context.WithTimeout(ctx, wb.DeliveryTimeout())
+	defer cancel()
+
+	started := time.Now()
+	res, err := s.httpClient.Post(deliveryCtx, wb.CallbackURL, "application/json", reader, headers)
+	metrics.ObserveDelivery(wb.Id, started)
+	// the caller gave up on this delivery (e.g. shutdown), this is not the
+	// receiver's fault so it does not count as an attempt
+	if err != nil && ctx.Err() != nil {
+		return s.markAsCanceled(ctx, event, err)
+	}
+	elapsed := time.Since(started)
 	event.Tries++
 
 	responseBody, responseCode, netErr := s.parseHttpResponse(res, err)
+	if err != nil {
+		metrics.DeliveryResponses.WithLabelValues(metrics.StatusClass(0, netErr != nil && netErr.Timeout())).Inc()
+	} else {
+		metrics.DeliveryResponses.WithLabelValues(metrics.StatusClass(responseCode, false)).Inc()
+	}
 	event.ResponseCode = responseCode
 	if responseBody != nil {
 		event.SetResponseBody(responseBody)
 	}
 
 	sentSuccessfully := event.CheckSuccessResponse(event.ResponseCode) && netErr == nil
+	s.recordAttempt(ctx, event, res, netErr, sentSuccessfully, started, elapsed)
 	if sentSuccessfully {
 		event.MarkAsDelivered()
-	} else if !event.IsRetryableCode() || event.ReachedMaxAttempts() {
+	} else if !event.IsRetryableCode() || event.ReachedMaxAttempts(policy) {
 		event.MarkAsFailed(responseBody)
+	} else {
+		event.MarkAsPending()
 	}
 
-	if err := s.repo.UpdateWebhookEventById(ctx, event.Id, *event); err != nil {
-		log.Error("update error on final state", "err", err)
-		return event, model.ErrWebhookEventDeliveryFailed(map[string]interface{}{"error": err.Error()})
+	if errWb := s.saveEvent(ctx, event); errWb != nil {
+		return event, errWb
 	}
 
 	if sentSuccessfully {
@@ -65,42 +123,148 @@ func (s *webhookService) SendWebhook(ctx context.Context, msg model.WebhookEvent
 		return event, model.ErrWebhookEventFails()
 	}
 
-	return event, model.ErrWebhookEventWillRetry(event.ResponseCode)
+	return event, model.ErrWebhookEventWillRetryAfter(policy.Backoff(event.Tries), event.ResponseCode)
 }
 
-func (s *webhookService) getAndValidatePreconditions(ctx context.Context, msg model.WebhookEventMessage) (*model.WebhookEvent, *model.Webhook, *model.WebhookError) {
+func (s *webhookService) getAndValidatePreconditions(ctx context.Context, msg model.WebhookEventMessage) (*model.WebhookEvent, *model.Webhook, *model.Tenant, *model.WebhookError) {
 	event, err := s.repo.GetWebhookEventByID(ctx, msg.Id)
 	if err != nil {
-		log.Error("query error", "err", err.Error())
-		return event, nil, model.ErrWebhookEventDeliveryFailed(map[string]interface{}{"error": err.Error()})
+		log.ErrorContext(ctx, "query error", "err", err.Error())
+		return event, nil, nil, model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
 	}
 	if event == nil {
-		log.Info("no webhook found with id", "id", msg.Id)
-		return event, nil, model.ErrWebhookEventNotFound(map[string]interface{}{"id": msg.Id})
+		log.InfoContext(ctx, "no webhook found with id", "id", msg.Id)
+		return event, nil, nil, model.ErrWebhookEventNotFound(map[string]interface{}{"id": msg.Id})
 	}
 
+	// the webhook and the tenant must belong to the tenant of the event
+	ctx = model.WithTenant(ctx, event.TenantId)
 	wb, err := s.repo.GetWebhookByID(ctx, event.WebhookId)
 	if err != nil {
-		log.Error("query error", "err", err.Error())
-		return event, nil, model.ErrWebhookEventDeliveryFailed(map[string]interface{}{"error": err.Error()})
+		log.ErrorContext(ctx, "query error", "err", err.Error())
+		return event, nil, nil, model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
 	}
 	if wb == nil {
-		log.Info("no webhook found with id", "id", event.WebhookId)
-		return event, nil, model.ErrWebhookEventNotFound(map[string]interface{}{"id": event.WebhookId})
+		log.InfoContext(ctx, "no webhook found with id", "id", event.WebhookId)
+		return event, nil, nil, model.ErrWebhookEventNotFound(map[string]interface{}{"id": event.WebhookId})
+	}
+
+	tenant, err := s.repo.GetTenantByID(ctx, event.TenantId)
+	if err != nil {
+		log.ErrorContext(ctx, "query error", "err", err.Error())
+		return event, wb, nil, model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
+	}
+	if tenant == nil {
+		log.InfoContext(ctx, "no tenant found with id", "id", event.TenantId)
+		return event, wb, nil, model.ErrTenantNotFound(map[string]interface{}{"id": event.TenantId})
 	}
 
-	if !event.IsPending() {
-		log.Info("webhook not is pending", "status", event.Status)
-		return event, wb, model.ErrWebhookEventNotPending("status", event.Status)
+	if event.IsInFlight() && !event.LeaseExpired(time.Now()) {
+		log.InfoContext(ctx, "webhook event already in flight", "owner", event.LeaseOwner)
+		return event, wb, tenant, model.ErrWebhookEventAlreadyInFlight("owner", event.LeaseOwner)
+	}
+	if !event.IsDeliverable(time.Now()) {
+		log.InfoContext(ctx, "webhook not is pending", "status", event.Status)
+		return event, wb, tenant, model.ErrWebhookEventNotPending("status", event.Status)
+	}
+	if event.ReachedMaxAttempts(tenant.Settings.Data().RetryPolicy()) {
+		return event, wb, tenant, model.ErrWebhookEventReachedMaxAttempts(map[string]interface{}{"tries": event.Tries})
 	}
-	if event.ReachedMaxAttempts() {
-		return event, wb, model.ErrWebhookEventReachedMaxAttempts(map[string]interface{}{"tries": event.Tries})
+	if !tenant.IsActive() {
+		return event, wb, tenant, model.ErrTenantIsDisabled(map[string]interface{}{"id": tenant.Id})
 	}
 	if !wb.IsActive() {
-		return event, wb, model.ErrWebhookIsDisabled(map[string]interface{}{"error": "webhook is not active"})
+		return event, wb, tenant, model.ErrWebhookIsDisabled(map[string]interface{}{"error": "webhook is not active"})
+	}
+
+	return event, wb, tenant, nil
+}
+
+// recordOutcome counts the delivery, errors that don't come from an attempt
+// mean it was skipped.
+func recordOutcome(errWb *model.WebhookError) {
+	outcome, reason := metrics.OUTCOME_SKIPPED, ""
+	switch {
+	case errWb == nil:
+		outcome = metrics.OUTCOME_DELIVERED
+	case errWb.Code == model.CODE_WILL_RETRY:
+		outcome = metrics.OUTCOME_RETRY
+	case errWb.Code == model.CODE_FAILED:
+		outcome = metrics.OUTCOME_FAILED
+	case errWb.Code == model.CODE_PAYLOAD_SERIALIZATION_FAILED:
+		outcome = metrics.OUTCOME_DEAD_LETTER
+	default:
+		reason = errWb.Code
+	}
+	metrics.Deliveries.WithLabelValues(outcome, reason).Inc()
+}
+
+// throttle applies the tenant rate limit before the claim, a throttled
+// delivery leaves the event as it is.
+func (s *webhookService) throttle(ctx context.Context, tenant *model.Tenant) *model.WebhookError {
+	if delay := s.limiters.reserve(tenant, time.Now()); delay > 0 {
+		log.InfoContext(ctx, "tenant rate limited", "tenant", tenant.Id, "delay", delay)
+		return model.ErrTenantRateLimited(delay, map[string]interface{}{"tenant": tenant.Id})
+	}
+	return nil
+}
+
+// claim takes the delivery lease, only one consumer can hold it so a
+// duplicated message is dropped instead of being sent twice.
+func (s *webhookService) claim(ctx context.Context, event *model.WebhookEvent, wb *model.Webhook) *model.WebhookError {
+	until := time.Now().Add(wb.LeaseDuration())
+	claimed, err := s.repo.ClaimWebhookEvent(ctx, event, s.leaseOwner, until)
+	if err != nil {
+		log.ErrorContext(ctx, "claim error", "err", err)
+		return model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
+	}
+	if !claimed {
+		log.InfoContext(ctx, "webhook event claimed by another consumer", "id", event.Id)
+		return model.ErrWebhookEventAlreadyInFlight("id", event.Id)
 	}
 
-	return event, wb, nil
+	return nil
+}
+
+func (s *webhookService) release(ctx context.Context, event *model.WebhookEvent) {
+	err := s.repo.ReleaseWebhookEvent(context.WithoutCancel(ctx), event, s.leaseOwner)
+
+	// the lease expires on its own and the sweeper picks the event up
+	var conflict *model.VersionConflictError
+	if errors.As(err, &conflict) {
+		log.InfoContext(ctx, "lease not released, the event is still in flight or changed concurrently", "id", event.Id, "version", conflict.Version)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "release error", "err", err)
+	}
+}
+
+// recordAttempt saves the attempt for the delivery stats, a failure is
+// logged and never fails the delivery.
+func (s *webhookService) recordAttempt(ctx context.Context, event *model.WebhookEvent, res *http.Response, netErr net.Error, success bool, started time.Time, elapsed time.Duration) {
+	if s.stats == nil {
+		return
+	}
+
+	// the codes of timeouts and network errors are not from the receiver
+	code := 0
+	if res != nil {
+		code = res.StatusCode
+	}
+	attempt := &model.DeliveryAttempt{
+		EventId:      event.Id,
+		WebhookId:    event.WebhookId,
+		TenantId:     event.TenantId,
+		Attempt:      event.Tries,
+		ResponseCode: code,
+		Outcome:      model.ClassifyDelivery(code, success, netErr != nil && netErr.Timeout()),
+		DurationMs:   int(elapsed.Milliseconds()),
+		CreatedAt:    started,
+	}
+	if err := s.stats.SaveDeliveryAttempt(context.WithoutCancel(ctx), attempt); err != nil {
+		log.ErrorContext(ctx, "delivery attempt record error", "err", err)
+	}
 }
 
 func (s *webhookService) parseHttpResponse(res *http.Response, err error) (body map[string]interface{}, statusCode int, netErr net.Error) {
@@ -144,11 +308,9 @@ func (s *webhookService) parseHttpResponse(res *http.Response, err error) (body
 }
 
 func (s *webhookService) markAsDeadLetter(ctx context.Context, event *model.WebhookEvent, serializationError error) (*model.WebhookEvent, *model.WebhookError) {
-	if err := s.repo.UpdateWebhookEventById(ctx, event.Id, model.WebhookEvent{
-		Status: model.WebhookEventsStatusDeadLetter,
-	}); err != nil {
-		log.Error("update error to dead letter failed", "err", err)
-		return event, model.ErrWebhookEventDeliveryFailed(map[string]interface{}{"error": err.Error()})
+	event.Status = model.WebhookEventsStatusDeadLetter
+	if errWb := s.saveEvent(ctx, event); errWb != nil {
+		return event, errWb
 	}
 
 	return event, model.ErrWebhookEventPayloadSerializationFailed(map[string]interface{}{
@@ -156,13 +318,65 @@ func (s *webhookService) markAsDeadLetter(ctx context.Context, event *model.Webh
 	})
 }
 
-func (s *webhookService) generateHMACSignature(payload model.Object, secret string) (string, error) {
+func (s *webhookService) markAsCanceled(ctx context.Context, event *model.WebhookEvent, cause error) (*model.WebhookEvent, *model.WebhookError) {
+	event.MarkAsPending()
+	event.SetLastError(map[string]interface{}{
+		"error": "canceled",
+		"cause": cause.Error(),
+	})
+
+	// ctx is already canceled, the outcome still has to be persisted
+	if errWb := s.saveEvent(context.WithoutCancel(ctx), event); errWb != nil {
+		return event, errWb
+	}
+
+	return event, model.ErrWebhookEventDeliveryCanceled(map[string]interface{}{"cause": cause.Error()})
+}
+
+// saveEvent persists event, a version conflict means another delivery moved
+// the event on (e.g. our lease expired) so its outcome wins over ours.
+func (s *webhookService) saveEvent(ctx context.Context, event *model.WebhookEvent) *model.WebhookError {
+	err := s.repo.UpdateWebhookEventById(ctx, event.Id, event)
+
+	var conflict *model.VersionConflictError
+	if errors.As(err, &conflict) {
+		log.InfoContext(ctx, "webhook event changed concurrently, dropping our update", "id", event.Id, "version", conflict.Version)
+		return model.ErrWebhookEventUpdateConflict(map[string]interface{}{"version": conflict.Version})
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "update error", "err", err)
+		return model.ErrWebhookEventInfrastructureUnavailable(map[string]interface{}{"error": err.Error()})
+	}
+
+	return nil
+}
+
+// signatureHeaders signs the payload with the signing mode of the tenant.
+func (s *webhookService) signatureHeaders(payload model.Object, secret string, mode model.SigningMode, now time.Time) (map[string]string, error) {
+	switch mode {
+	case model.SigningModeNone:
+		return map[string]string{}, nil
+	case model.SigningModeTimestampedHMAC:
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		signature, err := s.generateHMACSignature(payload, secret, timestamp+".")
+		return map[string]string{
+			"x-signature":           signature,
+			"x-signature-timestamp": timestamp,
+		}, err
+	default:
+		signature, err := s.generateHMACSignature(payload, secret, "")
+		return map[string]string{"x-signature": signature}, err
+	}
+}
+
+func (s *webhookService) generateHMACSignature(payload model.Object, secret string, prefix string) (string, error) {
 	jsonBytes, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
 
 	sig := hmac.New(sha256.New, []byte(secret))
+	sig.Write([]byte(prefix))
 	sig.Write(jsonBytes)
 
 	return fmt.Sprintf("sha256=%x", sig.Sum(nil)), nil
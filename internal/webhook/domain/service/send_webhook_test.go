@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	shttp "github.com/webhook-processor/internal/shared/http"
+	"github.com/webhook-processor/internal/webhook/adapters/repo"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"gorm.io/datatypes"
+)
+
+// failingUpdateRepo fails the final update of a delivery like a database
+// going away mid delivery.
+type failingUpdateRepo struct {
+	*repo.MemoryWebhookRepo
+}
+
+func (r failingUpdateRepo) UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) error {
+	return errors.New("connection reset")
+}
+
+func TestSendWebhookFailedSaveKeepsLease(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer receiver.Close()
+
+	memory := repo.NewMemoryWebhookRepo()
+	memory.SaveWebhook(model.Webhook{Id: 1, CallbackURL: receiver.URL, Secret: "secret", Status: model.WebhookStatusActive})
+	memory.SaveWebhookEvent(model.WebhookEvent{
+		Id:        "event-1",
+		WebhookId: 1,
+		Payload:   datatypes.NewJSONType(model.Object{"order": "o-1"}),
+		Status:    model.WebhookEventsStatusPending,
+	})
+
+	svc := NewWebhookService(failingUpdateRepo{memory}, nil, shttp.NewClient(shttp.ClientOpts{Timeout: time.Second}))
+	ctx := model.WithTenant(context.Background(), model.DEFAULT_TENANT_ID)
+	_, errWb := svc.SendWebhook(ctx, model.WebhookEventMessage{Id: "event-1", TenantId: model.DEFAULT_TENANT_ID})
+	if errWb == nil || !errWb.IsTransient() {
+		t.Fatalf("SendWebhook error = %v, want a transient infrastructure error", errWb)
+	}
+
+	event, err := memory.GetWebhookEventByID(ctx, "event-1")
+	if err != nil || event == nil {
+		t.Fatalf("get event: %v %v", event, err)
+	}
+	if !event.IsInFlight() || event.LeaseOwner != svc.leaseOwner || event.LeaseExpiresAt.IsZero() {
+		t.Fatalf("event orphaned: status=%s owner=%q expires=%s", event.Status, event.LeaseOwner, event.LeaseExpiresAt)
+	}
+
+	stuck, err := memory.ListStuckEvents(ctx, time.Now(), event.LeaseExpiresAt.Add(time.Second), 10)
+	if err != nil || len(stuck) != 1 {
+		t.Fatalf("sweeper sees %d stuck events (%v), want the in flight one once its lease expired", len(stuck), err)
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+)
+
+const STUCK_EVENT_SWEEPER_LOCK = "webhook-processor:stuck-event-sweeper"
+
+// StuckEventSweeper republishes pending events that have no live queue
+// message anymore (a publish failed, a message was acked on an
+// infrastructure error or the process died mid delivery) and in_flight
+// events whose lease expired.
+type StuckEventSweeper struct {
+	repo  ports.WebhookRepositoryPort
+	queue ports.QueuePort
+	// audit records the republished events, nil disables it
+	audit     ports.AuditPort
+	opts      StuckEventSweeperOpts
+	recovered atomic.Int64
+}
+
+type StuckEventSweeperOpts struct {
+	Interval time.Duration
+	// Threshold is how long after its expected next attempt an event is
+	// considered stuck
+	Threshold time.Duration
+	BatchSize int
+	Producer  string
+}
+
+func NewStuckEventSweeper(repo ports.WebhookRepositoryPort, queue ports.QueuePort, audit ports.AuditPort, opts StuckEventSweeperOpts) *StuckEventSweeper {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5 * time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	return &StuckEventSweeper{repo: repo, queue: queue, audit: audit, opts: opts}
+}
+
+func (s *StuckEventSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SweepOnce(ctx); err != nil {
+				log.ErrorContext(ctx, "stuck event sweep failed", "err", err)
+			}
+		}
+	}
+}
+
+// SweepOnce runs a single pass, only the replica holding the lock sweeps.
+func (s *StuckEventSweeper) SweepOnce(ctx context.Context) (int, error) {
+	ctx = model.AllTenants(ctx)
+	release, acquired, err := s.repo.TryLock(ctx, STUCK_EVENT_SWEEPER_LOCK)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		log.DebugContext(ctx, "stuck event sweeper running on another replica")
+		return 0, nil
+	}
+	defer release()
+
+	now := time.Now()
+	events, err := s.repo.ListStuckEvents(ctx, now.Add(-s.opts.Threshold), now, s.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, event := range events {
+		// a pending event may still have a delayed message on its way
+		if event.IsPending() && event.NextAttemptAt().Add(s.opts.Threshold).After(now) {
+			continue
+		}
+
+		before := replayState(&event)
+		if err := s.republish(ctx, &event); err != nil {
+			log.ErrorContext(ctx, "stuck event republish failed", "err", err, "id", event.Id)
+			continue
+		}
+		s.record(ctx, &event, before)
+		recovered++
+	}
+
+	s.recovered.Add(int64(recovered))
+	log.InfoContext(ctx, "stuck event sweep done", "scanned", len(events), "recovered", recovered, "recovered_total", s.recovered.Load())
+
+	return recovered, nil
+}
+
+// Recovered is the number of events republished since the sweeper started.
+func (s *StuckEventSweeper) Recovered() int64 {
+	return s.recovered.Load()
+}
+
+// record audits a republished event against its state before the
+// republish, a failure is logged and does not undo the republish.
+func (s *StuckEventSweeper) record(ctx context.Context, event *model.WebhookEvent, before map[string]interface{}) {
+	if s.audit == nil {
+		return
+	}
+
+	ctx = model.WithAuditReason(model.WithActor(ctx, "system:sweeper"), "stuck "+string(event.Status)+" event")
+	target := model.AuditTarget{Type: model.AuditTargetWebhookEvent, Id: event.Id, TenantId: event.TenantId}
+	if err := s.audit.Record(ctx, model.AuditActionEventReplay, target, before, replayState(event)); err != nil {
+		log.ErrorContext(ctx, "audit error", "err", err, "id", event.Id)
+	}
+}
+
+// replayState is what the audit entry of a replay diffs, the status is
+// kept by the republish and named in the entry reason.
+func replayState(event *model.WebhookEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     event.Status,
+		"version":    event.Version,
+		"updated_at": event.UpdatedAt,
+	}
+}
+
+func (s *StuckEventSweeper) republish(ctx context.Context, event *model.WebhookEvent) error {
+	msg := model.NewWebhookEventMessage(event, s.opts.Producer)
+	body, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+
+	if err := s.queue.Publish(ctx, body, ports.WebhookEventPublishOpts(msg, 0)); err != nil {
+		return err
+	}
+
+	// pushes the event out of the stale window until the new message runs,
+	// a conflict means a consumer got to it first and the message we just
+	// published will be dropped by the claim
+	return s.repo.TouchWebhookEvent(ctx, event)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/webhook-processor/internal/shared/logger"
+	"github.com/webhook-processor/internal/webhook/adapters/repo"
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"github.com/webhook-processor/internal/webhook/ports"
+	"gorm.io/datatypes"
+)
+
+type discardQueue struct{}
+
+func (discardQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) error {
+	return nil
+}
+
+// refusingQueue fails the publishes of one event like a broker dropping
+// the channel.
+type refusingQueue struct {
+	eventId   string
+	published []string
+}
+
+func (q *refusingQueue) Publish(ctx context.Context, msg []byte, opts ports.QueuePortPublishOpts) error {
+	if opts.Metadata.MessageId == q.eventId {
+		return errors.New("channel closed")
+	}
+	q.published = append(q.published, opts.Metadata.MessageId)
+	return nil
+}
+
+type recordedAudit struct {
+	entries []*model.AuditEntry
+}
+
+func (a *recordedAudit) Record(ctx context.Context, action model.AuditAction, target model.AuditTarget, before interface{}, after interface{}) error {
+	entry, err := model.NewAuditEntry(ctx, action, target, before, after)
+	if err != nil {
+		return err
+	}
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+func TestSweepRecordsReplayChanges(t *testing.T) {
+	memory := repo.NewMemoryWebhookRepo()
+	memory.SaveWebhook(model.Webhook{Id: 1, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive})
+	memory.SaveWebhookEvent(model.WebhookEvent{
+		Id:             "event-1",
+		WebhookId:      1,
+		Payload:        datatypes.NewJSONType(model.Object{"order": "o-1"}),
+		Status:         model.WebhookEventsStatusInFlight,
+		LeaseOwner:     "consumer-1",
+		LeaseExpiresAt: time.Now().Add(-time.Hour),
+	})
+
+	audit := &recordedAudit{}
+	recovered, err := NewStuckEventSweeper(memory, discardQueue{}, audit, StuckEventSweeperOpts{}).SweepOnce(context.Background())
+	if err != nil || recovered != 1 {
+		t.Fatalf("sweep = %d %v, want 1 event", recovered, err)
+	}
+	if len(audit.entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(audit.entries))
+	}
+
+	entry := audit.entries[0]
+	if entry.Reason != "stuck in_flight event" || entry.Actor != "system:sweeper" {
+		t.Errorf("entry actor %q reason %q", entry.Actor, entry.Reason)
+	}
+	changes := entry.Changes.Data()
+	if change, ok := changes["version"]; !ok || change.After.(float64) != change.Before.(float64)+1 {
+		t.Errorf("version change = %+v, want the bump of the republish", change)
+	}
+	if _, ok := changes["updated_at"]; !ok {
+		t.Errorf("changes %v miss updated_at", changes)
+	}
+}
+
+func TestSweepContinuesAfterPublishFailure(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	log.NewLogger(&log.NewLoggerOptions{Level: "info", Output: &logs}).SetAsDefaultForPackage()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	memory := repo.NewMemoryWebhookRepo()
+	memory.SaveWebhook(model.Webhook{Id: 1, CallbackURL: "http://localhost", Secret: "secret", Status: model.WebhookStatusActive})
+	for _, id := range []string{"event-1", "event-2"} {
+		memory.SaveWebhookEvent(model.WebhookEvent{
+			Id:             id,
+			WebhookId:      1,
+			Payload:        datatypes.NewJSONType(model.Object{"order": "o-1"}),
+			Status:         model.WebhookEventsStatusInFlight,
+			LeaseOwner:     "consumer-1",
+			LeaseExpiresAt: time.Now().Add(-time.Hour),
+		})
+	}
+
+	queue := &refusingQueue{eventId: "event-1"}
+	recovered, err := NewStuckEventSweeper(memory, queue, nil, StuckEventSweeperOpts{}).SweepOnce(context.Background())
+	if err != nil || recovered != 1 {
+		t.Fatalf("sweep = %d %v, want the other event recovered", recovered, err)
+	}
+	if len(queue.published) != 1 || queue.published[0] != "event-2" {
+		t.Errorf("published %v, want event-2", queue.published)
+	}
+	if !strings.Contains(logs.String(), "stuck event republish failed") || !strings.Contains(logs.String(), "id=event-1") {
+		t.Errorf("the failure was not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+	"golang.org/x/time/rate"
+)
+
+// tenantLimiters keeps one token bucket per tenant. Limits are enforced per
+// consumer process, N consumers deliver up to N times the tenant rate.
+type tenantLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newTenantLimiters() *tenantLimiters {
+	return &tenantLimiters{limiters: map[string]*rate.Limiter{}}
+}
+
+// reserve takes a token for one delivery of tenant, when none is left it
+// returns how long to wait for the next one and takes nothing.
+func (l *tenantLimiters) reserve(tenant *model.Tenant, now time.Time) time.Duration {
+	settings := tenant.Settings.Data()
+	if settings.RateLimitPerSecond <= 0 {
+		return 0
+	}
+
+	limiter := l.limiter(tenant.Id, rate.Limit(settings.RateLimitPerSecond), settings.RateLimitBurstOrDefault())
+	reservation := limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// limiter returns the bucket of a tenant, it is reconfigured in place when
+// the settings changed since it was created.
+func (l *tenantLimiters) limiter(id string, limit rate.Limit, burst int) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[id]
+	if !ok {
+		limiter = rate.NewLimiter(limit, burst)
+		l.limiters[id] = limiter
+	}
+	if limiter.Limit() != limit {
+		limiter.SetLimit(limit)
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurst(burst)
+	}
+	return limiter
+}
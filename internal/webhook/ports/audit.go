@@ -0,0 +1,25 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+// AuditRepositoryPort appends to the audit log, there is no way to change
+// or remove an entry. Reads are scoped to the tenant of the context, a
+// context without a scope reads nothing (see model.AllTenants).
+type AuditRepositoryPort interface {
+	SaveAuditEntry(ctx context.Context, entry *model.AuditEntry) error
+	ListAuditEntries(ctx context.Context, filter model.AuditFilter) (model.AuditPage, error)
+}
+
+// AuditPort records the actions of the actor of ctx, see model.WithActor.
+type AuditPort interface {
+	// Record diffs before and after, nil when the target did not exist
+	Record(ctx context.Context, action model.AuditAction, target model.AuditTarget, before interface{}, after interface{}) error
+}
+
+type AuditQueryPort interface {
+	ListAuditEntries(ctx context.Context, filter model.AuditFilter) (model.AuditPage, error)
+}
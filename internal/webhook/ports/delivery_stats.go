@@ -0,0 +1,30 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+// DeliveryStatsRepositoryPort stores delivery attempts and their rollups,
+// reads are scoped to the tenant of the context like WebhookRepositoryPort.
+type DeliveryStatsRepositoryPort interface {
+	SaveDeliveryAttempt(ctx context.Context, attempt *model.DeliveryAttempt) error
+	// ListDeliveryAttempts returns the attempts made in [from, to)
+	ListDeliveryAttempts(ctx context.Context, from time.Time, to time.Time) ([]model.DeliveryAttempt, error)
+	ListDeliveryStats(ctx context.Context, filter model.DeliveryStatsFilter) ([]model.WebhookDeliveryStats, error)
+	// UpsertDeliveryStats replaces the stats of the same webhook, granularity
+	// and bucket
+	UpsertDeliveryStats(ctx context.Context, stats []model.WebhookDeliveryStats) error
+	// DeleteDeliveryStats removes the buckets of granularity starting before
+	// before
+	DeleteDeliveryStats(ctx context.Context, granularity model.StatsGranularity, before time.Time) (int64, error)
+}
+
+type DeliveryStatsQueryPort interface {
+	// ListDeliveryStats and GetSLOReport fail with model.ErrUnknownWebhook
+	// when the webhook is not visible to the tenant of ctx
+	ListDeliveryStats(ctx context.Context, filter model.DeliveryStatsFilter) ([]model.WebhookDeliveryStats, error)
+	GetSLOReport(ctx context.Context, webhookId int) (*model.SLOReport, error)
+}
@@ -2,12 +2,68 @@ package ports
 
 import (
 	"context"
+	"strconv"
+	"time"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
 )
 
 type QueuePortPublishOpts struct {
-	Delay int
+	Delay    int
+	Metadata QueueMessageMetadata
+}
+
+// QueueMessageMetadata is mapped onto the broker message properties
+// (MessageId, Timestamp, Type and headers for AMQP).
+type QueueMessageMetadata struct {
+	MessageId string            `json:"message_id,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
+const (
+	HEADER_SCHEMA_VERSION = "x-schema-version"
+	HEADER_WEBHOOK_ID     = "x-webhook-id"
+	HEADER_ATTEMPT        = "x-attempt"
+	HEADER_TENANT_ID      = "x-tenant-id"
+	HEADER_PRODUCER       = "x-producer"
+	HEADER_TRACEPARENT    = "traceparent"
+	HEADER_TRACESTATE     = "tracestate"
+)
+
 type QueuePort interface {
 	Publish(ctx context.Context, msg []byte, opts QueuePortPublishOpts) error
 }
+
+func WebhookEventPublishOpts(msg model.WebhookEventMessage, delay int) QueuePortPublishOpts {
+	headers := map[string]string{
+		HEADER_SCHEMA_VERSION: strconv.Itoa(msg.Version),
+		HEADER_ATTEMPT:        strconv.Itoa(msg.Attempt),
+	}
+	if msg.WebhookId != 0 {
+		headers[HEADER_WEBHOOK_ID] = strconv.Itoa(msg.WebhookId)
+	}
+	if msg.TenantId != "" {
+		headers[HEADER_TENANT_ID] = msg.TenantId
+	}
+	if msg.Producer != "" {
+		headers[HEADER_PRODUCER] = msg.Producer
+	}
+	if msg.TraceParent != "" {
+		headers[HEADER_TRACEPARENT] = msg.TraceParent
+	}
+	if msg.TraceState != "" {
+		headers[HEADER_TRACESTATE] = msg.TraceState
+	}
+
+	return QueuePortPublishOpts{
+		Delay: delay,
+		Metadata: QueueMessageMetadata{
+			MessageId: msg.Id,
+			Type:      model.WEBHOOK_EVENT_MESSAGE_TYPE,
+			Timestamp: msg.EnqueuedAt,
+			Headers:   headers,
+		},
+	}
+}
@@ -0,0 +1,13 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/webhook-processor/internal/webhook/domain/model"
+)
+
+type WebhookQueryPort interface {
+	ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error)
+	// GetWebhookEvent returns nil when the event does not exist
+	GetWebhookEvent(ctx context.Context, id string) (*model.WebhookEvent, error)
+}
@@ -2,14 +2,49 @@ package ports
 
 import (
 	"context"
+	"time"
 
-	"github.com/webhook-processor/internal/webhook/adapters/repo"
 	"github.com/webhook-processor/internal/webhook/domain/model"
 )
 
+// WebhookRepositoryPort reads and writes only the rows of the tenant the
+// context is scoped to (see model.WithTenant), rows of other tenants are
+// reported as missing. A context without a scope sees no row, jobs working
+// across tenants opt in with model.AllTenants.
 type WebhookRepositoryPort interface {
+	GetTenantByID(ctx context.Context, id string) (*model.Tenant, error)
 	GetWebhookByID(ctx context.Context, id int) (*model.Webhook, error)
 	GetWebhookEventByID(ctx context.Context, id string) (*model.WebhookEvent, error)
-	UpdateWebhookEventById(ctx context.Context, id string, event model.WebhookEvent) error
-	Transaction(ctx *context.Context) repo.MyTransaction
+	// UpdateWebhookEventById writes every mutable column, zero values
+	// included. It fails with *model.VersionConflictError when event.Version
+	// is stale and bumps event.Version on success.
+	UpdateWebhookEventById(ctx context.Context, id string, event *model.WebhookEvent) error
+	// UpdateWebhookEventPayload writes the payload, which deliveries never
+	// change and UpdateWebhookEventById leaves alone, e.g. to encrypt it. It
+	// checks and bumps event.Version the same way.
+	UpdateWebhookEventPayload(ctx context.Context, event *model.WebhookEvent) error
+	// ClaimWebhookEvent moves a pending event (or one whose lease expired)
+	// to in_flight for owner, it reports false when someone else holds it or
+	// the event changed since it was read. event is updated on success.
+	ClaimWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string, until time.Time) (bool, error)
+	// ReleaseWebhookEvent clears the lease of owner once the event left
+	// in_flight, an event still in_flight keeps its lease until it expires.
+	// It fails with *model.VersionConflictError when nothing was released
+	// and bumps event.Version on success.
+	ReleaseWebhookEvent(ctx context.Context, event *model.WebhookEvent, owner string) error
+	// ListStuckEvents returns pending events not updated since pendingBefore
+	// and in_flight events whose lease expired before leaseExpiredBefore
+	ListStuckEvents(ctx context.Context, pendingBefore time.Time, leaseExpiredBefore time.Time, limit int) ([]model.WebhookEvent, error)
+	// TouchWebhookEvent bumps updated_at, it fails with
+	// *model.VersionConflictError when event.Version is stale.
+	TouchWebhookEvent(ctx context.Context, event *model.WebhookEvent) error
+	// ListWebhookEvents returns one page of the events matching filter,
+	// newest first
+	ListWebhookEvents(ctx context.Context, filter model.WebhookEventFilter) (model.WebhookEventPage, error)
+	// TryLock takes a cluster wide lock without waiting, release must be
+	// called once the work guarded by it is done
+	TryLock(ctx context.Context, name string) (release func(), acquired bool, err error)
+	// WithinTransaction runs fn as a unit of work, it is committed when fn
+	// returns nil and rolled back on error or panic. Calls can be nested.
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 }